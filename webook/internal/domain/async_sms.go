@@ -0,0 +1,45 @@
+package domain
+
+type AsyncSmsStatus uint8
+
+const (
+	AsyncSmsStatusUnknown AsyncSmsStatus = iota
+	// AsyncSmsStatusQueued 等待发送，或者失败了等待重试
+	AsyncSmsStatusQueued
+	// AsyncSmsStatusSending 被某个 worker 拿走了
+	AsyncSmsStatusSending
+	AsyncSmsStatusDelivered
+	// AsyncSmsStatusFailed 重试次数用完了
+	AsyncSmsStatusFailed
+)
+
+func (s AsyncSmsStatus) String() string {
+	switch s {
+	case AsyncSmsStatusQueued:
+		return "queued"
+	case AsyncSmsStatusSending:
+		return "sending"
+	case AsyncSmsStatusDelivered:
+		return "delivered"
+	case AsyncSmsStatusFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// AsyncSms 服务商都不可用的时候，先存起来等 worker 异步发送的短信
+type AsyncSms struct {
+	Id int64
+	// Key 幂等键，同一个 Key 只会发送一次
+	Key     string
+	TplId   string
+	Args    []string
+	Numbers []string
+	Status  AsyncSmsStatus
+	// 已经重试的次数和最多重试的次数
+	RetryCnt int
+	RetryMax int
+	LastErr  string
+	// Version 抢占的时候拿到的版本，更新状态的时候用它确认任务还是自己的
+	Version int64
+}
@@ -12,13 +12,55 @@ type User struct {
 	//Birthday string
 	Birthday time.Time
 	AboutMe  string
+	// Avatar 头像在对象存储里面的 key 前缀，不同尺寸的缩略图是 <Avatar>_<尺寸>.jpg
+	Avatar string
 
 	// UTC 0 的时区
 	Ctime time.Time
 
+	Privacy Privacy
+
 	//Addr Address
 }
 
+// ProfilePatch 编辑资料的时候传了哪些字段，nil 代表不改，空值代表清空
+type ProfilePatch struct {
+	Nickname *string
+	// 零值的 time.Time 代表清空生日
+	Birthday *time.Time
+	AboutMe  *string
+}
+
+// Privacy 公开主页上各个字段要不要隐藏，零值代表全部公开。
+// 昵称总是公开的，邮箱和手机号从来不公开
+type Privacy struct {
+	HideAboutMe  bool
+	HideAvatar   bool
+	HideJoinDate bool
+}
+
+// Public 别人能看到的部分，敏感字段和被隐藏的字段都清空
+func (u User) Public() User {
+	res := User{
+		Id:       u.Id,
+		Nickname: u.Nickname,
+		AboutMe:  u.AboutMe,
+		Avatar:   u.Avatar,
+		Ctime:    u.Ctime,
+		Privacy:  u.Privacy,
+	}
+	if u.Privacy.HideAboutMe {
+		res.AboutMe = ""
+	}
+	if u.Privacy.HideAvatar {
+		res.Avatar = ""
+	}
+	if u.Privacy.HideJoinDate {
+		res.Ctime = time.Time{}
+	}
+	return res
+}
+
 //type Address struct {
 //	Province string
 //	Region   string
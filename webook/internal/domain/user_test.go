@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_Public(t *testing.T) {
+	u := User{
+		Id:       1,
+		Email:    "a@b.com",
+		Password: "hash",
+		Phone:    "13812345678",
+		Nickname: "花里",
+		Birthday: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		AboutMe:  "hello",
+		Avatar:   "avatars/1",
+		Ctime:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	testCases := []struct {
+		name    string
+		privacy Privacy
+		want    User
+	}{
+		{
+			name: "全部公开，敏感字段也不返回",
+			want: User{Id: 1, Nickname: "花里", AboutMe: "hello", Avatar: "avatars/1", Ctime: u.Ctime},
+		},
+		{
+			name:    "全部隐藏，昵称还是公开的",
+			privacy: Privacy{HideAboutMe: true, HideAvatar: true, HideJoinDate: true},
+			want:    User{Id: 1, Nickname: "花里"},
+		},
+		{
+			name:    "只隐藏头像",
+			privacy: Privacy{HideAvatar: true},
+			want:    User{Id: 1, Nickname: "花里", AboutMe: "hello", Ctime: u.Ctime},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := u
+			u.Privacy = tc.privacy
+			tc.want.Privacy = tc.privacy
+			assert.Equal(t, tc.want, u.Public())
+		})
+	}
+}
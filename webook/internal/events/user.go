@@ -0,0 +1,70 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"gochuji/webook/pkg/outbox"
+	"gochuji/webook/pkg/samarax"
+)
+
+// TopicUserEvents 用户相关的事件都发到这个 topic，用 uid 做 key，
+// 同一个用户的事件按照发生的顺序消费
+const TopicUserEvents = "user_events"
+
+const (
+	EventUserRegistered     = "UserRegistered"
+	EventUserProfileUpdated = "UserProfileUpdated"
+)
+
+// UserRegistered 用户注册成功
+type UserRegistered struct {
+	// EventId 幂等键，和头部的 message-id 一样，消费者用它去重
+	EventId string `json:"event_id"`
+	Uid     int64  `json:"uid"`
+	Email   string `json:"email"`
+	Ctime   int64  `json:"ctime"`
+}
+
+// UserProfileUpdated 用户修改了昵称、生日、个人简介
+type UserProfileUpdated struct {
+	EventId  string `json:"event_id"`
+	Uid      int64  `json:"uid"`
+	Nickname string `json:"nickname"`
+	// YYYY-MM-DD，没有填是空字符串
+	Birthday string `json:"birthday"`
+	AboutMe  string `json:"about_me"`
+	Utime    int64  `json:"utime"`
+}
+
+// NewEventID 生成一个随机的幂等键
+func NewEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func NewUserRegistered(evt UserRegistered) (outbox.Message, error) {
+	return newMessage(EventUserRegistered, evt.Uid, evt.EventId, evt)
+}
+
+func NewUserProfileUpdated(evt UserProfileUpdated) (outbox.Message, error) {
+	return newMessage(EventUserProfileUpdated, evt.Uid, evt.EventId, evt)
+}
+
+func newMessage[T any](eventType string, uid int64, eventID string, evt T) (outbox.Message, error) {
+	msg, err := samarax.NewProducerMessage[T](samarax.JSONCodec[T]{}, TopicUserEvents,
+		[]byte(strconv.FormatInt(uid, 10)), evt)
+	if err != nil {
+		return outbox.Message{}, err
+	}
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(samarax.HeaderEventType), Value: []byte(eventType)},
+		sarama.RecordHeader{Key: []byte(samarax.HeaderMessageID), Value: []byte(eventID)},
+		sarama.RecordHeader{Key: []byte(samarax.HeaderProducedAt), Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	)
+	return outbox.NewMessage(msg)
+}
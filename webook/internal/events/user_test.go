@@ -0,0 +1,39 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/pkg/samarax"
+)
+
+func TestNewUserRegistered(t *testing.T) {
+	evt := UserRegistered{EventId: NewEventID(), Uid: 123, Email: "a@qq.com", Ctime: 1}
+	m, err := NewUserRegistered(evt)
+	require.NoError(t, err)
+	assert.Equal(t, TopicUserEvents, m.Topic)
+	assert.Equal(t, []byte("123"), m.Key)
+
+	msg, err := m.ProducerMessage()
+	require.NoError(t, err)
+	headers := make([]*sarama.RecordHeader, 0, len(msg.Headers))
+	for i := range msg.Headers {
+		headers = append(headers, &msg.Headers[i])
+	}
+	eventType, _ := samarax.Header(headers, samarax.HeaderEventType)
+	assert.Equal(t, EventUserRegistered, eventType)
+	id, _ := samarax.Header(headers, samarax.HeaderMessageID)
+	assert.Equal(t, evt.EventId, id)
+
+	got, err := samarax.JSONCodec[UserRegistered]{}.Decode(m.Value, headers)
+	require.NoError(t, err)
+	assert.Equal(t, evt, got)
+}
+
+func TestNewEventID(t *testing.T) {
+	assert.Len(t, NewEventID(), 32)
+	assert.NotEqual(t, NewEventID(), NewEventID())
+}
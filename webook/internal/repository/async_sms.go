@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gochuji/webook/internal/domain"
+	"gochuji/webook/internal/repository/dao"
+)
+
+var (
+	ErrDuplicateSmsKey   = dao.ErrDuplicateSmsKey
+	ErrAsyncSmsPreempted = dao.ErrAsyncSmsPreempted
+	// ErrNoAsyncSms 没有需要发送的短信
+	ErrNoAsyncSms = dao.ErrRecordNotFound
+)
+
+type AsyncSmsRepository struct {
+	dao *dao.AsyncSmsDAO
+}
+
+func NewAsyncSmsRepository(dao *dao.AsyncSmsDAO) *AsyncSmsRepository {
+	return &AsyncSmsRepository{dao: dao}
+}
+
+type smsConfig struct {
+	TplId   string   `json:"tpl_id"`
+	Args    []string `json:"args"`
+	Numbers []string `json:"numbers"`
+}
+
+// Add 返回带上 id 和 version 的任务，key 已经存在的话返回 ErrDuplicateSmsKey
+func (repo *AsyncSmsRepository) Add(ctx context.Context, s domain.AsyncSms) (domain.AsyncSms, error) {
+	cfg, err := json.Marshal(smsConfig{TplId: s.TplId, Args: s.Args, Numbers: s.Numbers})
+	if err != nil {
+		return domain.AsyncSms{}, err
+	}
+	res, err := repo.dao.Insert(ctx, dao.AsyncSms{
+		Key:      s.Key,
+		Config:   string(cfg),
+		Status:   uint8(s.Status),
+		RetryMax: s.RetryMax,
+	})
+	if err != nil {
+		return domain.AsyncSms{}, err
+	}
+	return repo.toDomain(res), nil
+}
+
+func (repo *AsyncSmsRepository) FindByKey(ctx context.Context, key string) (domain.AsyncSms, error) {
+	s, err := repo.dao.FindByKey(ctx, key)
+	if err != nil {
+		return domain.AsyncSms{}, err
+	}
+	return repo.toDomain(s), nil
+}
+
+// Preempt 没有需要发送的短信，或者被别的 worker 抢走了，返回 ErrNoAsyncSms
+func (repo *AsyncSmsRepository) Preempt(ctx context.Context, now time.Time, timeout time.Duration) (domain.AsyncSms, error) {
+	s, err := repo.dao.Preempt(ctx, now, timeout)
+	if err != nil {
+		return domain.AsyncSms{}, err
+	}
+	return repo.toDomain(s), nil
+}
+
+// MarkDelivered version 和抢占的时候拿到的对不上，返回 ErrAsyncSmsPreempted
+func (repo *AsyncSmsRepository) MarkDelivered(ctx context.Context, id, version int64) error {
+	return repo.dao.MarkDelivered(ctx, id, version)
+}
+
+// MarkRetry 和 MarkDelivered 一样要带上抢占的时候拿到的 version
+func (repo *AsyncSmsRepository) MarkRetry(ctx context.Context, id, version int64, retryCnt int, failed bool, lastErr string, nextRetryAt time.Time) error {
+	return repo.dao.MarkRetry(ctx, id, version, retryCnt, failed, lastErr, nextRetryAt)
+}
+
+func (repo *AsyncSmsRepository) toDomain(s dao.AsyncSms) domain.AsyncSms {
+	var cfg smsConfig
+	// 都是自己写进去的，不会出错
+	_ = json.Unmarshal([]byte(s.Config), &cfg)
+	return domain.AsyncSms{
+		Id:       s.Id,
+		Key:      s.Key,
+		TplId:    cfg.TplId,
+		Args:     cfg.Args,
+		Numbers:  cfg.Numbers,
+		Status:   domain.AsyncSmsStatus(s.Status),
+		RetryCnt: s.RetryCnt,
+		RetryMax: s.RetryMax,
+		LastErr:  s.LastErr,
+		Version:  s.Version,
+	}
+}
@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeviceCache 记录用户信任的设备，设备 id 由前端生成
+type DeviceCache struct {
+	cmd        redis.Cmdable
+	expiration time.Duration
+}
+
+func NewDeviceCache(cmd redis.Cmdable) *DeviceCache {
+	return &DeviceCache{
+		cmd: cmd,
+		// 一个月没有在这个设备上面重新勾选信任，就当作不可信了
+		expiration: time.Hour * 24 * 30,
+	}
+}
+
+func (c *DeviceCache) Add(ctx context.Context, uid int64, deviceID string) error {
+	key := c.key(uid)
+	pipe := c.cmd.TxPipeline()
+	pipe.SAdd(ctx, key, deviceID)
+	pipe.Expire(ctx, key, c.expiration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *DeviceCache) Contains(ctx context.Context, uid int64, deviceID string) (bool, error) {
+	return c.cmd.SIsMember(ctx, c.key(uid), deviceID).Result()
+}
+
+func (c *DeviceCache) key(uid int64) string {
+	return fmt.Sprintf("user:devices:%d", uid)
+}
@@ -49,6 +49,10 @@ func (c *UserCache) Set(ctx context.Context, du domain.User) error {
 	return c.cmd.Set(ctx, key, data, c.expiration).Err()
 }
 
+func (c *UserCache) Delete(ctx context.Context, uid int64) error {
+	return c.cmd.Del(ctx, c.key(uid)).Err()
+}
+
 func (c *UserCache) key(uid int64) string {
 	// user-info-
 	// user.info.
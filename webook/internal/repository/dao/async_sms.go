@@ -0,0 +1,145 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDuplicateSmsKey = errors.New("短信的幂等键冲突")
+	// ErrAsyncSmsPreempted 抢占超时之后任务被别的 worker 拿走了，不能再更新它的状态
+	ErrAsyncSmsPreempted = errors.New("短信已经被别的 worker 抢走了")
+)
+
+// 和 domain.AsyncSmsStatus 一样
+const (
+	asyncStatusQueued uint8 = iota + 1
+	asyncStatusSending
+	asyncStatusDelivered
+	asyncStatusFailed
+)
+
+type AsyncSmsDAO struct {
+	db *gorm.DB
+}
+
+func NewAsyncSmsDAO(db *gorm.DB) *AsyncSmsDAO {
+	return &AsyncSmsDAO{db: db}
+}
+
+// Insert 返回带上 id 的记录
+func (dao *AsyncSmsDAO) Insert(ctx context.Context, s AsyncSms) (AsyncSms, error) {
+	now := time.Now().UnixMilli()
+	s.Ctime = now
+	s.Utime = now
+	err := dao.db.WithContext(ctx).Create(&s).Error
+	if me, ok := err.(*mysql.MySQLError); ok {
+		const duplicateErr uint16 = 1062
+		if me.Number == duplicateErr {
+			return AsyncSms{}, ErrDuplicateSmsKey
+		}
+	}
+	return s, err
+}
+
+func (dao *AsyncSmsDAO) FindByKey(ctx context.Context, key string) (AsyncSms, error) {
+	var s AsyncSms
+	err := dao.db.WithContext(ctx).Where("`key` = ?", key).First(&s).Error
+	return s, err
+}
+
+// Preempt 抢占一个到了发送时间的任务。被抢占了超过 timeout 还没有结果的，
+// 说明那个 worker 可能崩溃了，也可以再抢过来
+func (dao *AsyncSmsDAO) Preempt(ctx context.Context, now time.Time, timeout time.Duration) (AsyncSms, error) {
+	var s AsyncSms
+	err := dao.db.WithContext(ctx).
+		Where("(status = ? AND next_retry_at <= ?) OR (status = ? AND utime < ?)",
+			asyncStatusQueued, now.UnixMilli(),
+			asyncStatusSending, now.Add(-timeout).UnixMilli()).
+		Order("id").First(&s).Error
+	if err != nil {
+		return AsyncSms{}, err
+	}
+	// 乐观锁，version 变了说明被别人抢走了
+	utime := time.Now().UnixMilli()
+	res := dao.db.WithContext(ctx).Model(&AsyncSms{}).
+		Where("id = ? AND version = ?", s.Id, s.Version).
+		Updates(map[string]any{
+			"status":  asyncStatusSending,
+			"version": s.Version + 1,
+			"utime":   utime,
+		})
+	if res.Error != nil {
+		return AsyncSms{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return AsyncSms{}, ErrRecordNotFound
+	}
+	s.Status = asyncStatusSending
+	s.Version++
+	s.Utime = utime
+	return s, nil
+}
+
+// MarkDelivered version 是抢占的时候拿到的，对不上说明任务被别人抢走了，返回 ErrAsyncSmsPreempted
+func (dao *AsyncSmsDAO) MarkDelivered(ctx context.Context, id, version int64) error {
+	return dao.update(ctx, id, version, map[string]any{
+		"status": asyncStatusDelivered,
+	})
+}
+
+// MarkRetry 记录这次失败。failed 为 true 代表重试次数用完了，不再发送，
+// 否则放回队列，到了 nextRetryAt 才能再被抢占
+func (dao *AsyncSmsDAO) MarkRetry(ctx context.Context, id, version int64, retryCnt int, failed bool, lastErr string, nextRetryAt time.Time) error {
+	status := asyncStatusQueued
+	if failed {
+		status = asyncStatusFailed
+	}
+	// varchar(255) 按字符算
+	if r := []rune(lastErr); len(r) > 255 {
+		lastErr = string(r[:255])
+	}
+	return dao.update(ctx, id, version, map[string]any{
+		"retry_cnt":     retryCnt,
+		"status":        status,
+		"last_err":      lastErr,
+		"next_retry_at": nextRetryAt.UnixMilli(),
+	})
+}
+
+// update 只有 version 还是自己抢占的时候拿到的才更新，顺便把 version 加一。
+// 不然卡住的 worker 醒过来之后，可能把别人已经发出去的短信又放回队列
+func (dao *AsyncSmsDAO) update(ctx context.Context, id, version int64, updates map[string]any) error {
+	updates["version"] = version + 1
+	updates["utime"] = time.Now().UnixMilli()
+	res := dao.db.WithContext(ctx).Model(&AsyncSms{}).
+		Where("id = ? AND version = ?", id, version).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAsyncSmsPreempted
+	}
+	return nil
+}
+
+type AsyncSms struct {
+	Id  int64  `gorm:"primaryKey;autoIncrement"`
+	Key string `gorm:"size:128;uniqueIndex"`
+	// Config 模板 id、参数和手机号，JSON
+	Config   string `gorm:"type:text"`
+	Status   uint8  `gorm:"not null;index:idx_status_utime,priority:1;index:idx_status_next_retry,priority:1"`
+	RetryCnt int
+	RetryMax int
+	LastErr  string `gorm:"size:255"`
+	// NextRetryAt 失败之后退避，到了这个时间才重试
+	NextRetryAt int64 `gorm:"not null;default:0;index:idx_status_next_retry,priority:2"`
+	// Version 抢占用的乐观锁，utime 只到毫秒，同一毫秒里面两次抢占会分不出来
+	Version int64 `gorm:"not null;default:0"`
+	Ctime   int64
+	Utime   int64 `gorm:"index:idx_status_utime,priority:2"`
+}
@@ -0,0 +1,38 @@
+package dao
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestAsyncSmsDAO_MarkVersion(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	dao := NewAsyncSmsDAO(db)
+	ctx := context.Background()
+
+	mock.ExpectExec("UPDATE `async_sms` SET .* WHERE id = \\? AND version = \\?").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(1), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, dao.MarkDelivered(ctx, 1, 2))
+
+	// 别的 worker 已经接手了，version 对不上
+	mock.ExpectExec("UPDATE `async_sms` SET .* WHERE id = \\? AND version = \\?").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, dao.MarkDelivered(ctx, 1, 2), ErrAsyncSmsPreempted)
+	mock.ExpectExec("UPDATE `async_sms` SET .* WHERE id = \\? AND version = \\?").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, dao.MarkRetry(ctx, 1, 2, 1, false, "down", time.Now()), ErrAsyncSmsPreempted)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
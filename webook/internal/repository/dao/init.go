@@ -1,8 +1,15 @@
 package dao
 
-import "gorm.io/gorm"
+import (
+	"gochuji/webook/pkg/outbox"
+	"gorm.io/gorm"
+)
 
 func InitTables(db *gorm.DB) error {
 	// 严格来说，这个不是优秀实践
-	return db.AutoMigrate(&User{})
+	err := db.AutoMigrate(&User{}, &AsyncSms{})
+	if err != nil {
+		return err
+	}
+	return outbox.InitTable(db)
 }
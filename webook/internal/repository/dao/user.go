@@ -3,9 +3,10 @@ package dao
 import (
 	"context"
 	"errors"
-	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
+	"gochuji/webook/pkg/outbox"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -24,11 +25,27 @@ func NewUserDAO(db *gorm.DB) *UserDAO {
 	}
 }
 
-func (dao *UserDAO) Insert(ctx context.Context, u User) error {
+// OutboxFunc 根据写进去之后的数据生成要发出去的事件，
+// 事件和 users 表的修改在同一个事务里面提交
+type OutboxFunc func(u User) ([]outbox.Message, error)
+
+// UserProfile 编辑资料的时候要更新的字段，nil 代表不更新
+type UserProfile struct {
+	Nickname *string
+	Birthday *int64
+	AboutMe  *string
+}
+
+func (dao *UserDAO) Insert(ctx context.Context, u User, events OutboxFunc) error {
 	now := time.Now().UnixMilli()
 	u.Ctime = now
 	u.Utime = now
-	err := dao.db.WithContext(ctx).Create(&u).Error
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return insertOutbox(tx, u, events)
+	})
 	if me, ok := err.(*mysql.MySQLError); ok {
 		const duplicateErr uint16 = 1062
 		if me.Number == duplicateErr {
@@ -39,29 +56,96 @@ func (dao *UserDAO) Insert(ctx context.Context, u User) error {
 	return err
 }
 
+func insertOutbox(tx *gorm.DB, u User, events OutboxFunc) error {
+	if events == nil {
+		return nil
+	}
+	msgs, err := events(u)
+	if err != nil {
+		return err
+	}
+	return outbox.InsertTx(tx, msgs...)
+}
+
 func (dao *UserDAO) FindByEmail(ctx context.Context, email string) (User, error) {
 	var u User
 	err := dao.db.WithContext(ctx).Where("email=?", email).First(&u).Error
 	return u, err
 }
 
-func (dao *UserDAO) FindByID(ctx *gin.Context, userID int64) (User, error) {
+func (dao *UserDAO) FindByID(ctx context.Context, userID int64) (User, error) {
 	var u User
 	err := dao.db.WithContext(ctx).Where("id=?", userID).First(&u).Error
 	return u, err
 }
 
-func (dao *UserDAO) UpdateById(ctx context.Context, entity User) error {
+// FindByNickname 昵称不是唯一的，最多返回 limit 个
+func (dao *UserDAO) FindByNickname(ctx context.Context, nickname string, limit int) ([]User, error) {
+	var us []User
+	err := dao.db.WithContext(ctx).Where("nickname = ?", nickname).
+		Order("id").Limit(limit).Find(&us).Error
+	return us, err
+}
+
+// UpdateProfile 只更新 p 里面传了并且和原来不一样的字段，什么都没变的话不写 outbox
+func (dao *UserDAO) UpdateProfile(ctx context.Context, id int64, p UserProfile, events OutboxFunc) error {
+	if p.Nickname == nil && p.Birthday == nil && p.AboutMe == nil {
+		return nil
+	}
+	return dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 锁住这一行，事件里面要带完整的资料
+		var u User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&u).Error
+		if err != nil {
+			return err
+		}
+		updates := map[string]any{}
+		if p.Nickname != nil && *p.Nickname != u.Nickname {
+			u.Nickname = *p.Nickname
+			updates["nickname"] = u.Nickname
+		}
+		if p.Birthday != nil && *p.Birthday != u.Birthday {
+			u.Birthday = *p.Birthday
+			updates["birthday"] = u.Birthday
+		}
+		if p.AboutMe != nil && *p.AboutMe != u.AboutMe {
+			u.AboutMe = *p.AboutMe
+			updates["about_me"] = u.AboutMe
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		u.Utime = time.Now().UnixMilli()
+		updates["utime"] = u.Utime
+		err = tx.Model(&User{}).Where("id = ?", id).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+		return insertOutbox(tx, u, events)
+	})
+}
 
-	// 这种写法依赖于 GORM 的零值和主键更新特性
-	// Update 非零值 WHERE id = ?
-	//return dao.db.WithContext(ctx).Updates(&entity).Error
-	return dao.db.WithContext(ctx).Model(&entity).Where("id = ?", entity.Id).
+func (dao *UserDAO) UpdatePassword(ctx context.Context, uid int64, hash string) error {
+	return dao.db.WithContext(ctx).Model(&User{}).Where("id = ?", uid).
 		Updates(map[string]any{
 			"utime":    time.Now().UnixMilli(),
-			"nickname": entity.Nickname,
-			"birthday": entity.Birthday,
-			"about_me": entity.AboutMe,
+			"password": hash,
+		}).Error
+}
+
+func (dao *UserDAO) UpdatePrivacy(ctx context.Context, uid int64, privacy uint8) error {
+	return dao.db.WithContext(ctx).Model(&User{}).Where("id = ?", uid).
+		Updates(map[string]any{
+			"utime":   time.Now().UnixMilli(),
+			"privacy": privacy,
+		}).Error
+}
+
+func (dao *UserDAO) UpdateAvatar(ctx context.Context, uid int64, avatar string) error {
+	return dao.db.WithContext(ctx).Model(&User{}).Where("id = ?", uid).
+		Updates(map[string]any{
+			"utime":  time.Now().UnixMilli(),
+			"avatar": avatar,
 		}).Error
 }
 
@@ -76,9 +160,12 @@ type User struct {
 
 	// 个人资料
 	Phone    string `gorm:"size:20;uniqueIndex" json:"phone" validate:"omitempty,e164"`
-	Nickname string `gorm:"size:50" json:"nickname" validate:"required,max=50"`
+	Nickname string `gorm:"size:50;index" json:"nickname" validate:"required,max=50"`
 	Birthday int64  `gorm:"size:10" json:"birthday" validate:"omitempty,date"` // YYYY-MM-DD 格式
 	AboutMe  string `gorm:"type:text" json:"about_me" validate:"max=500"`
+	Avatar   string `gorm:"size:255" json:"avatar"`
+	// Privacy 公开主页的隐私设置，每一位代表一个字段要不要隐藏
+	Privacy uint8 `gorm:"not null;default:0" json:"privacy"`
 }
 
 //type Address struct {
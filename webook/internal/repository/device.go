@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+
+	"gochuji/webook/internal/repository/cache"
+)
+
+type DeviceRepository struct {
+	cache *cache.DeviceCache
+}
+
+func NewDeviceRepository(c *cache.DeviceCache) *DeviceRepository {
+	return &DeviceRepository{
+		cache: c,
+	}
+}
+
+func (repo *DeviceRepository) Trust(ctx context.Context, uid int64, deviceID string) error {
+	return repo.cache.Add(ctx, uid, deviceID)
+}
+
+func (repo *DeviceRepository) IsTrusted(ctx context.Context, uid int64, deviceID string) (bool, error) {
+	return repo.cache.Contains(ctx, uid, deviceID)
+}
@@ -2,11 +2,12 @@ package repository
 
 import (
 	"context"
-	"github.com/gin-gonic/gin"
 	"gochuji/webook/internal/domain"
+	"gochuji/webook/internal/events"
 	"gochuji/webook/internal/repository/cache"
 	"gochuji/webook/internal/repository/dao"
-	"log"
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/outbox"
 	"time"
 )
 
@@ -27,10 +28,19 @@ func NewUserRepository(dao *dao.UserDAO, c *cache.UserCache) *UserRepository {
 	}
 }
 
+// Create 注册成功的同时写一条 UserRegistered 到 outbox
 func (repo *UserRepository) Create(ctx context.Context, u domain.User) error {
 	return repo.dao.Insert(ctx, dao.User{
 		Email:    u.Email,
 		Password: u.Password,
+	}, func(u dao.User) ([]outbox.Message, error) {
+		msg, err := events.NewUserRegistered(events.UserRegistered{
+			EventId: events.NewEventID(),
+			Uid:     u.Id,
+			Email:   u.Email,
+			Ctime:   u.Ctime,
+		})
+		return []outbox.Message{msg}, err
 	})
 }
 
@@ -42,7 +52,7 @@ func (repo *UserRepository) FindByEmail(ctx context.Context, email string) (doma
 	return repo.toDomain(u), nil
 }
 
-func (repo *UserRepository) FindByID(ctx *gin.Context, userID int64) (domain.User, error) {
+func (repo *UserRepository) FindByID(ctx context.Context, userID int64) (domain.User, error) {
 	du, err := repo.cache.Get(ctx, userID)
 	// 只要 err 为 nil，就返回
 	if err == nil {
@@ -58,23 +68,83 @@ func (repo *UserRepository) FindByID(ctx *gin.Context, userID int64) (domain.Use
 	err = repo.cache.Set(ctx, du)
 	if err != nil {
 		// 网络崩了，也可能是 redis 崩了
-		log.Println(err)
+		logger.FromContext(ctx).Warn("写用户缓存失败", logger.Int64("uid", userID), logger.Error(err))
 	}
 	return du, nil
 }
 
-func (repo *UserRepository) UpdateNonZeroFields(ctx context.Context,
-	user domain.User) error {
-	err := repo.dao.UpdateById(ctx, repo.toEntity(user))
+func (repo *UserRepository) FindByNickname(ctx context.Context, nickname string, limit int) ([]domain.User, error) {
+	us, err := repo.dao.FindByNickname(ctx, nickname, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.User, 0, len(us))
+	for _, u := range us {
+		res = append(res, repo.toDomain(u))
+	}
+	return res, nil
+}
+
+func (repo *UserRepository) UpdatePrivacy(ctx context.Context, uid int64, privacy domain.Privacy) error {
+	err := repo.dao.UpdatePrivacy(ctx, uid, toPrivacyBits(privacy))
 	if err != nil {
 		return err
 	}
-	err = repo.cache.Set(ctx, user)
+	err = repo.cache.Delete(ctx, uid)
 	if err != nil {
 		// 网络崩了，也可能是 redis 崩了
-		log.Println(err)
+		logger.FromContext(ctx).Warn("删除用户缓存失败", logger.Int64("uid", uid), logger.Error(err))
 	}
-	return err
+	return nil
+}
+
+// UpdateProfile 只更新 patch 里面传了的字段
+func (repo *UserRepository) UpdateProfile(ctx context.Context, uid int64, patch domain.ProfilePatch) error {
+	p := dao.UserProfile{Nickname: patch.Nickname, AboutMe: patch.AboutMe}
+	if patch.Birthday != nil {
+		birthday := birthdayMilli(*patch.Birthday)
+		p.Birthday = &birthday
+	}
+	err := repo.dao.UpdateProfile(ctx, uid, p, func(u dao.User) ([]outbox.Message, error) {
+		msg, err := events.NewUserProfileUpdated(events.UserProfileUpdated{
+			EventId:  events.NewEventID(),
+			Uid:      u.Id,
+			Nickname: u.Nickname,
+			Birthday: birthdayDate(u.Birthday),
+			AboutMe:  u.AboutMe,
+			Utime:    u.Utime,
+		})
+		return []outbox.Message{msg}, err
+	})
+	if err != nil {
+		return err
+	}
+	// 只更新了部分字段，user 不完整，删掉缓存，下次查询的时候再从数据库加载
+	err = repo.cache.Delete(ctx, uid)
+	if err != nil {
+		// 网络崩了，也可能是 redis 崩了
+		logger.FromContext(ctx).Warn("删除用户缓存失败", logger.Int64("uid", uid), logger.Error(err))
+	}
+	return nil
+}
+
+// UpdatePassword 只更新密码的哈希，缓存里面不放密码相关的判断，所以不用动缓存
+func (repo *UserRepository) UpdatePassword(ctx context.Context, uid int64, hash string) error {
+	return repo.dao.UpdatePassword(ctx, uid, hash)
+}
+
+// UpdateAvatar 更新头像之后直接删缓存，下次查询的时候再从数据库加载
+func (repo *UserRepository) UpdateAvatar(ctx context.Context, uid int64, avatar string) error {
+	err := repo.dao.UpdateAvatar(ctx, uid, avatar)
+	if err != nil {
+		return err
+	}
+	err = repo.cache.Delete(ctx, uid)
+	if err != nil {
+		// 网络崩了，也可能是 redis 崩了
+		logger.FromContext(ctx).Warn("删除用户缓存失败", logger.Int64("uid", uid), logger.Error(err))
+	}
+	return nil
 }
 
 func (repo *UserRepository) toEntity(u domain.User) dao.User {
@@ -82,10 +152,28 @@ func (repo *UserRepository) toEntity(u domain.User) dao.User {
 		Id:       u.Id,
 		Email:    u.Email,
 		Password: u.Password,
-		Birthday: u.Birthday.UnixMilli(),
+		Birthday: birthdayMilli(u.Birthday),
 		AboutMe:  u.AboutMe,
 		Nickname: u.Nickname,
+		Avatar:   u.Avatar,
+		Privacy:  toPrivacyBits(u.Privacy),
+	}
+}
+
+// birthdayMilli 没有填生日的时候存 0，不然零值的 time.Time 会变成一个很大的负数
+func birthdayMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// birthdayDate web 层按照 UTC 解析的生日，没有填的话是空字符串
+func birthdayDate(milli int64) string {
+	if milli == 0 {
+		return ""
 	}
+	return time.UnixMilli(milli).UTC().Format(time.DateOnly)
 }
 
 func (repo *UserRepository) toDomain(u dao.User) domain.User {
@@ -97,5 +185,36 @@ func (repo *UserRepository) toDomain(u dao.User) domain.User {
 		Nickname: u.Nickname,
 		Birthday: time.UnixMilli(u.Birthday),
 		AboutMe:  u.AboutMe,
+		Avatar:   u.Avatar,
+		Ctime:    time.UnixMilli(u.Ctime),
+		Privacy:  fromPrivacyBits(u.Privacy),
+	}
+}
+
+const (
+	privacyHideAboutMe uint8 = 1 << iota
+	privacyHideAvatar
+	privacyHideJoinDate
+)
+
+func toPrivacyBits(p domain.Privacy) uint8 {
+	var bits uint8
+	if p.HideAboutMe {
+		bits |= privacyHideAboutMe
+	}
+	if p.HideAvatar {
+		bits |= privacyHideAvatar
+	}
+	if p.HideJoinDate {
+		bits |= privacyHideJoinDate
+	}
+	return bits
+}
+
+func fromPrivacyBits(bits uint8) domain.Privacy {
+	return domain.Privacy{
+		HideAboutMe:  bits&privacyHideAboutMe != 0,
+		HideAvatar:   bits&privacyHideAvatar != 0,
+		HideJoinDate: bits&privacyHideJoinDate != 0,
 	}
 }
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gochuji/webook/internal/domain"
+)
+
+func TestPrivacyBits(t *testing.T) {
+	// 每一种组合存进去再读出来都不变
+	for bits := uint8(0); bits < 8; bits++ {
+		p := fromPrivacyBits(bits)
+		assert.Equal(t, bits, toPrivacyBits(p))
+		assert.Equal(t, p, fromPrivacyBits(toPrivacyBits(p)))
+	}
+	assert.Equal(t, domain.Privacy{}, fromPrivacyBits(0))
+	assert.Equal(t, domain.Privacy{HideAboutMe: true, HideAvatar: true, HideJoinDate: true},
+		fromPrivacyBits(privacyHideAboutMe|privacyHideAvatar|privacyHideJoinDate))
+	// 以后加的位不影响现在的字段
+	assert.Equal(t, domain.Privacy{HideAvatar: true}, fromPrivacyBits(privacyHideAvatar|0x80))
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	_ "golang.org/x/image/webp"
+
+	"gochuji/webook/internal/repository"
+	"gochuji/webook/pkg/imagex"
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/storage"
+)
+
+var (
+	ErrAvatarTooLarge    = errors.New("头像文件太大")
+	ErrAvatarUnsupported = errors.New("不支持的图片格式")
+	ErrAvatarDimension   = errors.New("头像的长宽不合适")
+)
+
+// AvatarSizes 缩略图固定的几个尺寸，最后一个是默认展示的尺寸
+var AvatarSizes = []int{64, 128, 256}
+
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+const (
+	// 长宽的限制，防止一张很小的图片解压出来特别大
+	avatarMinSide = 32
+	avatarMaxSide = 4096
+)
+
+type AvatarService struct {
+	repo    *repository.UserRepository
+	storage storage.ObjectStorage
+	maxSize int64
+}
+
+func NewAvatarService(repo *repository.UserRepository, storage storage.ObjectStorage, maxSize int64) *AvatarService {
+	return &AvatarService{
+		repo:    repo,
+		storage: storage,
+		maxSize: maxSize,
+	}
+}
+
+// MaxSize 上传文件的大小限制，单位是字节
+func (svc *AvatarService) MaxSize() int64 {
+	return svc.maxSize
+}
+
+// Upload 校验图片，生成各个尺寸的缩略图放进对象存储，然后更新用户的头像。
+// 返回新的头像 key
+func (svc *AvatarService) Upload(ctx context.Context, uid int64, data []byte) (string, error) {
+	if int64(len(data)) > svc.maxSize {
+		return "", ErrAvatarTooLarge
+	}
+	// 不相信客户端传过来的 Content-Type，自己看文件内容
+	if !avatarTypes[mimetype.Detect(data).String()] {
+		return "", ErrAvatarUnsupported
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrAvatarUnsupported
+	}
+	if min(cfg.Width, cfg.Height) < avatarMinSide || max(cfg.Width, cfg.Height) > avatarMaxSide {
+		return "", ErrAvatarDimension
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrAvatarUnsupported
+	}
+
+	old, err := svc.repo.FindByID(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	// 每次上传都用新的 key，这样 CDN 和浏览器的缓存不会拿到老的头像
+	avatar := fmt.Sprintf("avatars/%d/%d", uid, time.Now().UnixMilli())
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, imagex.Thumbnail(img, size), &jpeg.Options{Quality: 85})
+		if err != nil {
+			return "", err
+		}
+		err = svc.storage.Put(ctx, avatarKey(avatar, size), &buf, int64(buf.Len()), "image/jpeg")
+		if err != nil {
+			return "", err
+		}
+	}
+	err = svc.repo.UpdateAvatar(ctx, uid, avatar)
+	if err != nil {
+		return "", err
+	}
+	svc.deleteAvatar(ctx, old.Avatar)
+	return avatar, nil
+}
+
+// URL 返回某个尺寸头像的访问地址，没有头像就返回空字符串
+func (svc *AvatarService) URL(avatar string, size int) string {
+	if avatar == "" {
+		return ""
+	}
+	return svc.storage.URL(avatarKey(avatar, size))
+}
+
+// deleteAvatar 删掉老的头像，失败了也没关系，只是多占一点空间
+func (svc *AvatarService) deleteAvatar(ctx context.Context, avatar string) {
+	if avatar == "" {
+		return
+	}
+	for _, size := range AvatarSizes {
+		err := svc.storage.Delete(ctx, avatarKey(avatar, size))
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			logger.FromContext(ctx).Warn("删除老头像失败", logger.String("avatar", avatar), logger.Error(err))
+		}
+	}
+}
+
+func avatarKey(avatar string, size int) string {
+	return fmt.Sprintf("%s_%d.jpg", avatar, size)
+}
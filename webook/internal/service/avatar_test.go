@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"gochuji/webook/internal/domain"
+	"gochuji/webook/internal/repository"
+	"gochuji/webook/internal/repository/cache"
+	"gochuji/webook/internal/repository/dao"
+	"gochuji/webook/pkg/storage"
+)
+
+func newPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+func TestAvatarService_Upload(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "太大", data: make([]byte, 64<<10+1), wantErr: ErrAvatarTooLarge},
+		{name: "不是图片", data: []byte("hello world"), wantErr: ErrAvatarUnsupported},
+		// 文件头是 PNG，内容坏了
+		{name: "图片坏了", data: newPNG(t, 64, 64)[:40], wantErr: ErrAvatarUnsupported},
+		{name: "太小", data: newPNG(t, 16, 16), wantErr: ErrAvatarDimension},
+		{name: "太大的长宽", data: newPNG(t, 4097, 32), wantErr: ErrAvatarDimension},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 校验不通过的不会查数据库，也不会写存储
+			svc := NewAvatarService(nil, nil, 64<<10)
+			_, err := svc.Upload(context.Background(), 1, tc.data)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+
+	t.Run("成功，删掉老的头像", func(t *testing.T) {
+		sqlDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer sqlDB.Close()
+		db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+			&gorm.Config{SkipDefaultTransaction: true})
+		require.NoError(t, err)
+		rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		ctx := context.Background()
+		// 用户信息在缓存里面，不用查数据库
+		require.NoError(t, cache.NewUserCache(rdb).Set(ctx, domain.User{Id: 1, Avatar: "avatars/1/old"}))
+
+		root := t.TempDir()
+		st := storage.NewLocalStorage(root, "http://localhost/static")
+		for _, size := range AvatarSizes {
+			require.NoError(t, st.Put(ctx, avatarKey("avatars/1/old", size), strings.NewReader("old"), 3, "image/jpeg"))
+		}
+		mock.ExpectExec("UPDATE `users` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		svc := NewAvatarService(repository.NewUserRepository(dao.NewUserDAO(db), cache.NewUserCache(rdb)), st, 64<<10)
+		avatar, err := svc.Upload(ctx, 1, newPNG(t, 300, 200))
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+		assert.True(t, strings.HasPrefix(avatar, "avatars/1/"))
+
+		for _, size := range AvatarSizes {
+			// 新的各个尺寸都生成了，都是 JPEG
+			f, err := os.Open(filepath.Join(root, avatarKey(avatar, size)))
+			require.NoError(t, err)
+			cfg, format, err := image.DecodeConfig(f)
+			_ = f.Close()
+			require.NoError(t, err)
+			assert.Equal(t, "jpeg", format)
+			assert.Equal(t, size, cfg.Width)
+			assert.Equal(t, size, cfg.Height)
+			// 老的删掉了
+			_, err = os.Stat(filepath.Join(root, avatarKey("avatars/1/old", size)))
+			assert.True(t, os.IsNotExist(err))
+		}
+		assert.Equal(t, "http://localhost/static/"+avatar+"_256.jpg", svc.URL(avatar, 256))
+		assert.Empty(t, svc.URL("", 256))
+		// 缓存删掉了，下次从数据库加载新的头像
+		_, err = cache.NewUserCache(rdb).Get(ctx, 1)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+
+	"gochuji/webook/internal/repository"
+	"gochuji/webook/pkg/logger"
+)
+
+// DeviceService 管理用户信任的设备。在不可信的设备上，用户自己的邮箱和手机号也要打码
+type DeviceService struct {
+	repo *repository.DeviceRepository
+}
+
+func NewDeviceService(repo *repository.DeviceRepository) *DeviceService {
+	return &DeviceService{
+		repo: repo,
+	}
+}
+
+func (svc *DeviceService) Trust(ctx context.Context, uid int64, deviceID string) error {
+	if deviceID == "" {
+		return nil
+	}
+	return svc.repo.Trust(ctx, uid, deviceID)
+}
+
+// IsTrusted 没有设备 id，或者查询出错，都当作不可信，宁可多打码
+func (svc *DeviceService) IsTrusted(ctx context.Context, uid int64, deviceID string) bool {
+	if deviceID == "" {
+		return false
+	}
+	ok, err := svc.repo.IsTrusted(ctx, uid, deviceID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("查询信任设备失败", logger.Int64("uid", uid), logger.Error(err))
+		return false
+	}
+	return ok
+}
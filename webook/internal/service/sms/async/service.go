@@ -0,0 +1,173 @@
+package async
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gochuji/webook/internal/domain"
+	"gochuji/webook/internal/repository"
+	"gochuji/webook/internal/service/sms"
+	"gochuji/webook/pkg/failover"
+	"gochuji/webook/pkg/logger"
+)
+
+// Repository *repository.AsyncSmsRepository 实现了它
+type Repository interface {
+	Add(ctx context.Context, s domain.AsyncSms) (domain.AsyncSms, error)
+	FindByKey(ctx context.Context, key string) (domain.AsyncSms, error)
+	Preempt(ctx context.Context, now time.Time, timeout time.Duration) (domain.AsyncSms, error)
+	MarkDelivered(ctx context.Context, id, version int64) error
+	MarkRetry(ctx context.Context, id, version int64, retryCnt int, failed bool, lastErr string, nextRetryAt time.Time) error
+}
+
+// Service 服务商都不可用的时候，把短信存到数据库里面，由 StartAsyncCycle 异步发送。
+// 同一个幂等键只会发送一次，调用方可以用 Status 查询发送的结果
+type Service struct {
+	svc  sms.Service
+	repo Repository
+	l    logger.LoggerV1
+	// 每条短信最多重试几次
+	retryMax int
+	// 抢占之后多久没有结果，就认为那个 worker 崩溃了，可以重新抢占
+	preemptTimeout time.Duration
+	// 第一次重试前等多久，之后每次翻倍，最多 maxBackoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// 没有任务或者发送失败之后歇多久
+	idle time.Duration
+
+	// 测试的时候换成假的时钟
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+}
+
+func NewService(svc sms.Service, repo Repository, l logger.LoggerV1) *Service {
+	return &Service{
+		svc:            svc,
+		repo:           repo,
+		l:              l,
+		retryMax:       3,
+		preemptTimeout: time.Minute,
+		backoff:        10 * time.Second,
+		maxBackoff:     10 * time.Minute,
+		idle:           time.Second,
+		now:            time.Now,
+		after:          time.After,
+	}
+}
+
+// Send 实现了 sms.Service，随机生成一个幂等键。降级的时候存起来，返回 nil
+func (s *Service) Send(ctx context.Context, tplId string, args []string, numbers ...string) error {
+	_, err := s.SendWithKey(ctx, newKey(), tplId, args, numbers...)
+	return err
+}
+
+// SendWithKey 先用 key 占一条 sending 的记录，再同步发送，服务商都不可用的时候放回队列异步发送。
+// key 已经存在的话说明别的调用处理过了，直接返回它的状态，不会再发
+func (s *Service) SendWithKey(ctx context.Context, key, tplId string, args []string, numbers ...string) (domain.AsyncSmsStatus, error) {
+	// 靠唯一索引保证并发的请求只有一个能走到发送这一步
+	task, err := s.repo.Add(ctx, domain.AsyncSms{
+		Key:      key,
+		TplId:    tplId,
+		Args:     args,
+		Numbers:  numbers,
+		Status:   domain.AsyncSmsStatusSending,
+		RetryMax: s.retryMax,
+	})
+	if errors.Is(err, repository.ErrDuplicateSmsKey) {
+		return s.Status(ctx, key)
+	}
+	if err != nil {
+		return domain.AsyncSmsStatusUnknown, err
+	}
+
+	err = s.svc.Send(ctx, tplId, args, numbers...)
+	switch {
+	case err == nil:
+		if err = s.repo.MarkDelivered(ctx, task.Id, task.Version); err != nil {
+			// 短信已经发出去了，没记下来的话抢占超时之后 worker 还会再发一次
+			s.l.Error("记录短信发送结果失败", logger.String("key", key), logger.Error(err))
+		}
+		return domain.AsyncSmsStatusDelivered, nil
+	case errors.Is(err, failover.ErrNoAvailable) || errors.Is(err, failover.ErrAllFailed):
+		s.l.Warn("短信服务商不可用，转异步发送", logger.String("key", key), logger.Error(err))
+		if err = s.repo.MarkRetry(ctx, task.Id, task.Version, 0, false, err.Error(), s.now()); err != nil {
+			// 记录还是 sending，抢占超时之后 worker 一样会接手
+			s.l.Warn("放回队列失败", logger.String("key", key), logger.Error(err))
+		}
+		return domain.AsyncSmsStatusQueued, nil
+	default:
+		// 不是服务商不可用，重试也没有用
+		markErr := s.repo.MarkRetry(ctx, task.Id, task.Version, 0, true, err.Error(), s.now())
+		return domain.AsyncSmsStatusFailed, errors.Join(err, markErr)
+	}
+}
+
+// Status 查询 key 对应的短信发送到哪一步了，没有这个 key 返回 repository.ErrNoAsyncSms
+func (s *Service) Status(ctx context.Context, key string) (domain.AsyncSmsStatus, error) {
+	task, err := s.repo.FindByKey(ctx, key)
+	if err != nil {
+		return domain.AsyncSmsStatusUnknown, err
+	}
+	return task.Status, nil
+}
+
+// StartAsyncCycle 不断地发送存起来的短信，直到 ctx 结束
+func (s *Service) StartAsyncCycle(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := s.AsyncSend(ctx)
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, repository.ErrNoAsyncSms):
+			// 没有任务，歇一会
+		default:
+			// 服务商多半还没恢复，歇一会再看别的任务
+			s.l.Warn("异步发送短信失败", logger.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+		case <-s.after(s.idle):
+		}
+	}
+}
+
+// AsyncSend 抢占一条到了发送时间的短信发送。发送失败会按照退避时间放回队列，并且返回发送的错误
+func (s *Service) AsyncSend(ctx context.Context) error {
+	task, err := s.repo.Preempt(ctx, s.now(), s.preemptTimeout)
+	if err != nil {
+		return err
+	}
+	err = s.svc.Send(ctx, task.TplId, task.Args, task.Numbers...)
+	if err == nil {
+		return s.repo.MarkDelivered(ctx, task.Id, task.Version)
+	}
+	retryCnt := task.RetryCnt + 1
+	failed := retryCnt >= task.RetryMax
+	if failed {
+		s.l.Error("短信重试次数用完了",
+			logger.String("key", task.Key),
+			logger.Int("retries", retryCnt),
+			logger.Error(err))
+	}
+	markErr := s.repo.MarkRetry(ctx, task.Id, task.Version, retryCnt, failed, err.Error(), s.now().Add(s.retryBackoff(retryCnt)))
+	return errors.Join(err, markErr)
+}
+
+// retryBackoff 第 retryCnt 次失败之后等多久再重试
+func (s *Service) retryBackoff(retryCnt int) time.Duration {
+	d := s.backoff
+	for i := 1; i < retryCnt && d < s.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, s.maxBackoff)
+}
+
+func newKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,331 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/internal/domain"
+	"gochuji/webook/internal/repository"
+	"gochuji/webook/internal/service/sms"
+	smsfailover "gochuji/webook/internal/service/sms/failover"
+	"gochuji/webook/internal/service/sms/memory"
+	"gochuji/webook/pkg/failover"
+	"gochuji/webook/pkg/logger"
+)
+
+type memRepo struct {
+	mu    sync.Mutex
+	tasks []domain.AsyncSms
+	// id -> 什么时候才能重试
+	nextRetry map[int64]time.Time
+	// id -> 什么时候被抢占的
+	preempted map[int64]time.Time
+}
+
+func (r *memRepo) Add(ctx context.Context, s domain.AsyncSms) (domain.AsyncSms, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tasks {
+		if t.Key == s.Key {
+			return domain.AsyncSms{}, repository.ErrDuplicateSmsKey
+		}
+	}
+	s.Id = int64(len(r.tasks) + 1)
+	r.tasks = append(r.tasks, s)
+	return s, nil
+}
+
+func (r *memRepo) FindByKey(ctx context.Context, key string) (domain.AsyncSms, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tasks {
+		if t.Key == key {
+			return t, nil
+		}
+	}
+	return domain.AsyncSms{}, repository.ErrNoAsyncSms
+}
+
+func (r *memRepo) Preempt(ctx context.Context, now time.Time, timeout time.Duration) (domain.AsyncSms, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.preempted == nil {
+		r.preempted = make(map[int64]time.Time)
+	}
+	for i, t := range r.tasks {
+		ready := t.Status == domain.AsyncSmsStatusQueued && !now.Before(r.nextRetry[t.Id])
+		timedOut := t.Status == domain.AsyncSmsStatusSending && now.Sub(r.preempted[t.Id]) > timeout
+		if ready || timedOut {
+			r.preempted[t.Id] = now
+			r.tasks[i].Status = domain.AsyncSmsStatusSending
+			r.tasks[i].Version++
+			return r.tasks[i], nil
+		}
+	}
+	return domain.AsyncSms{}, repository.ErrNoAsyncSms
+}
+
+// task 和 dao 一样，version 对不上就不让更新
+func (r *memRepo) task(id, version int64) (*domain.AsyncSms, error) {
+	t := &r.tasks[id-1]
+	if t.Version != version {
+		return nil, repository.ErrAsyncSmsPreempted
+	}
+	t.Version++
+	return t, nil
+}
+
+func (r *memRepo) MarkDelivered(ctx context.Context, id, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, err := r.task(id, version)
+	if err != nil {
+		return err
+	}
+	t.Status = domain.AsyncSmsStatusDelivered
+	return nil
+}
+
+func (r *memRepo) MarkRetry(ctx context.Context, id, version int64, retryCnt int, failed bool, lastErr string, nextRetryAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, err := r.task(id, version)
+	if err != nil {
+		return err
+	}
+	if r.nextRetry == nil {
+		r.nextRetry = make(map[int64]time.Time)
+	}
+	r.nextRetry[id] = nextRetryAt
+	t.RetryCnt, t.LastErr = retryCnt, lastErr
+	t.Status = domain.AsyncSmsStatusQueued
+	if failed {
+		t.Status = domain.AsyncSmsStatusFailed
+	}
+	return nil
+}
+
+func (r *memRepo) retryCnt(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tasks {
+		if t.Key == key {
+			return t.RetryCnt
+		}
+	}
+	return -1
+}
+
+// fakeClock 只有 Advance 的时候时间才会往前走
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+type clockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, clockWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func (c *fakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func newTestService() (*Service, *memory.Service) {
+	provider := memory.NewService("fake", logger.NewNopLogger())
+	cfg := failover.DefaultConfig()
+	// 每次都试探，方便测试
+	cfg.ProbeInterval = 0
+	pool := failover.NewPool[sms.Service](cfg).Add("fake", provider)
+	return NewService(smsfailover.NewService(pool), &memRepo{}, logger.NewNopLogger()), provider
+}
+
+func TestService_SendWithKey(t *testing.T) {
+	svc, provider := newTestService()
+	ctx := context.Background()
+
+	status, err := svc.SendWithKey(ctx, "k1", "login", []string{"1234"}, "13800000000")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusDelivered, status)
+	// 同一个 key 不会再发
+	status, err = svc.SendWithKey(ctx, "k1", "login", []string{"1234"}, "13800000000")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusDelivered, status)
+	assert.Len(t, provider.Sent(), 1)
+
+	// 服务商挂了，存起来
+	provider.SetOutage(errors.New("down"))
+	status, err = svc.SendWithKey(ctx, "k2", "login", []string{"5678"}, "13800000000")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusQueued, status)
+
+	// 恢复之后 worker 发出去
+	provider.SetOutage(nil)
+	require.NoError(t, svc.AsyncSend(ctx))
+	status, err = svc.Status(ctx, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusDelivered, status)
+	assert.ErrorIs(t, svc.AsyncSend(ctx), repository.ErrNoAsyncSms)
+}
+
+func TestService_SendWithKeyConcurrent(t *testing.T) {
+	svc, provider := newTestService()
+	ctx := context.Background()
+
+	const n = 10
+	var wg sync.WaitGroup
+	statuses := make([]domain.AsyncSmsStatus, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status, err := svc.SendWithKey(ctx, "k", "login", []string{"1234"}, "13800000000")
+			assert.NoError(t, err)
+			statuses[i] = status
+		}(i)
+	}
+	wg.Wait()
+	// 同一个 key 只有一个请求能发出去，别的拿到的是那条记录的状态
+	assert.Len(t, provider.Sent(), 1)
+	for _, status := range statuses {
+		assert.Contains(t, []domain.AsyncSmsStatus{
+			domain.AsyncSmsStatusSending, domain.AsyncSmsStatusDelivered}, status)
+	}
+}
+
+func TestService_AsyncSendStaleWorker(t *testing.T) {
+	svc, provider := newTestService()
+	ctx := context.Background()
+	clock := newFakeClock()
+	svc.now = clock.Now
+	repo := svc.repo.(*memRepo)
+	provider.SetOutage(errors.New("down"))
+	_, err := svc.SendWithKey(ctx, "k", "login", nil, "13800000000")
+	require.NoError(t, err)
+	provider.SetOutage(nil)
+
+	// 第一个 worker 抢到之后卡住了，超时之后被第二个 worker 接手发出去
+	stale, err := repo.Preempt(ctx, clock.Now(), svc.preemptTimeout)
+	require.NoError(t, err)
+	clock.Advance(svc.preemptTimeout + time.Second)
+	require.NoError(t, svc.AsyncSend(ctx))
+
+	// 第一个 worker 醒过来，不能把发出去的短信放回队列
+	err = repo.MarkRetry(ctx, stale.Id, stale.Version, 1, false, "timeout", clock.Now())
+	assert.ErrorIs(t, err, repository.ErrAsyncSmsPreempted)
+	assert.ErrorIs(t, repo.MarkDelivered(ctx, stale.Id, stale.Version), repository.ErrAsyncSmsPreempted)
+	status, err := svc.Status(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusDelivered, status)
+	assert.ErrorIs(t, svc.AsyncSend(ctx), repository.ErrNoAsyncSms)
+	assert.Len(t, provider.Sent(), 1)
+}
+
+func TestService_AsyncSendRetryLimit(t *testing.T) {
+	svc, provider := newTestService()
+	ctx := context.Background()
+	provider.SetOutage(errors.New("down"))
+	status, err := svc.SendWithKey(ctx, "k", "login", nil, "13800000000")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusQueued, status)
+
+	clock := newFakeClock()
+	svc.now = clock.Now
+	for i := 1; i <= svc.retryMax; i++ {
+		require.Error(t, svc.AsyncSend(ctx))
+		// 还没到退避时间，不会再发
+		assert.ErrorIs(t, svc.AsyncSend(ctx), repository.ErrNoAsyncSms)
+		clock.Advance(svc.retryBackoff(i))
+	}
+	status, err = svc.Status(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, domain.AsyncSmsStatusFailed, status)
+	assert.ErrorIs(t, svc.AsyncSend(ctx), repository.ErrNoAsyncSms)
+}
+
+func TestService_StartAsyncCycle(t *testing.T) {
+	svc, provider := newTestService()
+	clock := newFakeClock()
+	svc.now, svc.after = clock.Now, clock.After
+	repo := svc.repo.(*memRepo)
+	provider.SetOutage(errors.New("down"))
+	status, err := svc.SendWithKey(context.Background(), "k", "login", nil, "13800000000")
+	require.NoError(t, err)
+	require.Equal(t, domain.AsyncSmsStatusQueued, status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.StartAsyncCycle(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// 第一次失败之后歇着，不会马上把重试次数用完
+	require.Eventually(t, func() bool {
+		return repo.retryCnt("k") == 1 && clock.Waiting() == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(svc.idle)
+	require.Eventually(t, func() bool { return clock.Waiting() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, repo.retryCnt("k"))
+
+	// 到了退避时间再试一次
+	clock.Advance(svc.retryBackoff(1))
+	require.Eventually(t, func() bool {
+		return repo.retryCnt("k") == 2 && clock.Waiting() == 1
+	}, time.Second, time.Millisecond)
+
+	// 服务商恢复之后发出去
+	provider.SetOutage(nil)
+	clock.Advance(svc.retryBackoff(2))
+	require.Eventually(t, func() bool {
+		status, err := svc.Status(context.Background(), "k")
+		return err == nil && status == domain.AsyncSmsStatusDelivered
+	}, time.Second, time.Millisecond)
+	assert.Len(t, provider.Sent(), 1)
+}
@@ -0,0 +1,23 @@
+package failover
+
+import (
+	"context"
+
+	"gochuji/webook/internal/service/sms"
+	"gochuji/webook/pkg/failover"
+)
+
+// Service 在多个短信服务商之间故障转移
+type Service struct {
+	pool *failover.Pool[sms.Service]
+}
+
+func NewService(pool *failover.Pool[sms.Service]) *Service {
+	return &Service{pool: pool}
+}
+
+func (s *Service) Send(ctx context.Context, tplId string, args []string, numbers ...string) error {
+	return s.pool.Do(ctx, func(ctx context.Context, svc sms.Service) error {
+		return svc.Send(ctx, tplId, args, numbers...)
+	})
+}
@@ -0,0 +1,56 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/internal/service/sms"
+	"gochuji/webook/internal/service/sms/memory"
+	"gochuji/webook/pkg/failover"
+	"gochuji/webook/pkg/logger"
+)
+
+func TestService_Send(t *testing.T) {
+	l := logger.NewNopLogger()
+	primary, backup := memory.NewService("primary", l), memory.NewService("backup", l)
+	cfg := failover.Config{
+		MinSuccessRate: 0.5,
+		MinSamples:     4,
+		Timeout:        20 * time.Millisecond,
+		ProbeInterval:  0,
+		ReadmitAfter:   2,
+	}
+	pool := failover.NewPool[sms.Service](cfg).Add("primary", primary).Add("backup", backup)
+	svc := NewService(pool)
+	ctx := context.Background()
+	send := func() error {
+		return svc.Send(ctx, "login", []string{"123456"}, "13800000000")
+	}
+
+	// 主服务商超时，换到备用的
+	primary.SetDelay(time.Second)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, send())
+	}
+	assert.Empty(t, primary.Sent())
+	assert.Len(t, backup.Sent(), 4)
+	// 主服务商被摘掉了
+	assert.Equal(t, []string{"backup"}, pool.Healthy())
+
+	// 主服务商恢复之后，试探成功两次重新启用
+	primary.SetDelay(0)
+	require.NoError(t, send())
+	require.NoError(t, send())
+	assert.Len(t, primary.Sent(), 2)
+	assert.Equal(t, []string{"primary", "backup"}, pool.Healthy())
+
+	// 全部故障
+	primary.SetOutage(errors.New("primary down"))
+	backup.SetOutage(errors.New("backup down"))
+	assert.ErrorIs(t, send(), failover.ErrAllFailed)
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gochuji/webook/pkg/logger"
+)
+
+// Service 不真的发短信，只是打印出来，开发和测试的时候用。
+// 可以模拟服务商故障：一直返回错误，或者变慢
+type Service struct {
+	name string
+	l    logger.LoggerV1
+
+	mu    sync.Mutex
+	err   error
+	delay time.Duration
+	sent  []Message
+}
+
+type Message struct {
+	TplId   string
+	Args    []string
+	Numbers []string
+}
+
+func NewService(name string, l logger.LoggerV1) *Service {
+	return &Service{name: name, l: l}
+}
+
+func (s *Service) Send(ctx context.Context, tplId string, args []string, numbers ...string) error {
+	s.mu.Lock()
+	err, delay := s.err, s.delay
+	s.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.sent = append(s.sent, Message{TplId: tplId, Args: args, Numbers: numbers})
+	s.mu.Unlock()
+	// 参数里面是验证码，号码也不能打出来
+	s.l.Info("发送短信",
+		logger.String("provider", s.name),
+		logger.String("tpl_id", tplId),
+		logger.Int("numbers", len(numbers)))
+	return nil
+}
+
+// SetOutage 之后的发送都返回 err，传 nil 恢复
+func (s *Service) SetOutage(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// SetDelay 每次发送都要等这么久，用来模拟超时
+func (s *Service) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Sent 成功发出去的短信
+func (s *Service) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.sent...)
+}
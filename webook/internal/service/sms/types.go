@@ -0,0 +1,9 @@
+package sms
+
+import "context"
+
+// Service 发送短信的抽象，不同的服务商各自实现
+type Service interface {
+	// Send tplId 是短信模板的 id，args 是模板的参数
+	Send(ctx context.Context, tplId string, args []string, numbers ...string) error
+}
@@ -3,38 +3,49 @@ package service
 import (
 	"context"
 	"errors"
-	"github.com/gin-gonic/gin"
 	"gochuji/webook/internal/domain"
 	"gochuji/webook/internal/repository"
-	"golang.org/x/crypto/bcrypt"
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/password"
 )
 
 var (
 	ErrDuplicateEmail        = repository.ErrDuplicateEmail
 	ErrInvalidUserOrPassword = errors.New("用户不存在或者密码不对")
+	ErrUserNotFound          = repository.ErrUserNotFound
 )
 
 type UserService struct {
-	repo *repository.UserRepository
+	repo   *repository.UserRepository
+	policy *password.Policy
+	// 换了算法或者调高了参数之后，老用户下次登录成功的时候会重新哈希
+	hasher password.Hasher
 }
 
-func NewUserService(repo *repository.UserRepository) *UserService {
+func NewUserService(repo *repository.UserRepository, policy *password.Policy, hasher password.Hasher) *UserService {
 	return &UserService{
-		repo: repo,
+		repo:   repo,
+		policy: policy,
+		hasher: hasher,
 	}
 }
 
 func (svc *UserService) Signup(ctx context.Context, u domain.User) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	// web 层已经校验过格式了，这里再完整检查一遍，包括泄露库
+	err := svc.policy.Check(ctx, u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hash)
+	hash, err := svc.hasher.Hash(u.Password)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
 	return svc.repo.Create(ctx, u)
 }
 
-// Login 函数用于用户登录，接收一个context.Context、email和password作为参数，返回一个domain.User和一个error
-func (svc *UserService) Login(ctx context.Context, email string, password string) (domain.User, error) {
+// Login 函数用于用户登录，接收一个context.Context、email和pwd作为参数，返回一个domain.User和一个error
+func (svc *UserService) Login(ctx context.Context, email string, pwd string) (domain.User, error) {
 	// 根据email查找用户
 	u, err := svc.repo.FindByEmail(ctx, email)
 	// 如果找不到用户，返回ErrInvalidUserOrPassword错误
@@ -47,21 +58,68 @@ func (svc *UserService) Login(ctx context.Context, email string, password string
 	}
 
 	// 将用户密码和输入的密码进行比对
-	err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	err = svc.hasher.Verify(u.Password, pwd)
 	// 如果比对失败，返回ErrInvalidUserOrPassword错误
-	if err != nil {
+	if errors.Is(err, password.ErrMismatch) {
 		return domain.User{}, ErrInvalidUserOrPassword
 	}
+	// 哈希本身坏了，或者是不认识的算法
+	if err != nil {
+		return domain.User{}, err
+	}
+	svc.rehashIfNeeded(ctx, u, pwd)
 	// 返回用户
 	return u, nil
 }
 
-func (svc *UserService) FindByID(ctx *gin.Context, userIDS int64) (domain.User, error) {
+// rehashIfNeeded 老的哈希不是现在配置的算法，或者参数比现在的低（比如 bcrypt 的 cost），
+// 就趁着拿到明文重新哈希一次。失败了也不影响登录
+func (svc *UserService) rehashIfNeeded(ctx context.Context, u domain.User, pwd string) {
+	if !svc.hasher.NeedsRehash(u.Password) {
+		return
+	}
+	hash, err := svc.hasher.Hash(pwd)
+	if err != nil {
+		logger.FromContext(ctx).Warn("重新哈希密码失败", logger.Int64("uid", u.Id), logger.Error(err))
+		return
+	}
+	err = svc.repo.UpdatePassword(ctx, u.Id, hash)
+	if err != nil {
+		logger.FromContext(ctx).Warn("保存重新哈希的密码失败", logger.Int64("uid", u.Id), logger.Error(err))
+	}
+}
+
+func (svc *UserService) FindByID(ctx context.Context, userIDS int64) (domain.User, error) {
 	return svc.repo.FindByID(ctx, userIDS)
 }
 
+// FindPublicByID 别人看到的主页，只包含公开的字段
+func (svc *UserService) FindPublicByID(ctx context.Context, uid int64) (domain.User, error) {
+	u, err := svc.repo.FindByID(ctx, uid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	return u.Public(), nil
+}
+
+// FindPublicByNickname 按照昵称查找用户，昵称可能重复，所以返回多个
+func (svc *UserService) FindPublicByNickname(ctx context.Context, nickname string, limit int) ([]domain.User, error) {
+	us, err := svc.repo.FindByNickname(ctx, nickname, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range us {
+		us[i] = us[i].Public()
+	}
+	return us, nil
+}
+
+func (svc *UserService) UpdatePrivacy(ctx context.Context, uid int64, privacy domain.Privacy) error {
+	return svc.repo.UpdatePrivacy(ctx, uid, privacy)
+}
+
 func (svc *UserService) UpdateNonSensitiveInfo(ctx context.Context,
-	user domain.User) error {
+	uid int64, patch domain.ProfilePatch) error {
 	// UpdateNicknameAndXXAnd
-	return svc.repo.UpdateNonZeroFields(ctx, user)
+	return svc.repo.UpdateProfile(ctx, uid, patch)
 }
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maskEmail abcdef@qq.com => a****f@qq.com
+func maskEmail(email string) string {
+	name, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return maskMiddle(email, 1, 1)
+	}
+	return maskMiddle(name, 1, 1) + "@" + domain
+}
+
+// maskPhone 13812345678 => 138****5678
+func maskPhone(phone string) string {
+	return maskMiddle(phone, 3, 4)
+}
+
+// maskMiddle 保留前 keepHead 个和后 keepTail 个字符，中间换成 ****。
+// 太短的话就全部打码
+func maskMiddle(s string, keepHead, keepTail int) string {
+	if s == "" {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= keepHead+keepTail {
+		return strings.Repeat("*", utf8.RuneCountInString(s))
+	}
+	return string(runes[:keepHead]) + "****" + string(runes[len(runes)-keepTail:])
+}
@@ -0,0 +1,15 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "a****f@qq.com", maskEmail("abcdef@qq.com"))
+	assert.Equal(t, "**@qq.com", maskEmail("ab@qq.com"))
+	assert.Equal(t, "138****5678", maskPhone("13812345678"))
+	assert.Equal(t, "", maskPhone(""))
+	assert.Equal(t, "花****花", maskMiddle("花里胡哨的花", 1, 1))
+}
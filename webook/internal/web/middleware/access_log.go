@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"gochuji/webook/pkg/logger"
+)
+
+// DefaultRedactKeys 这些字段不管出现在头部还是 JSON 里面，都不能原样打到日志里
+var DefaultRedactKeys = []string{"password", "confirmPassword", "Authorization", "x-jwt-token"}
+
+const redacted = "***"
+
+// AccessLogMiddlewareBuilder 每个请求打一条访问日志。
+// 请求体、响应体默认不打，打的话最多 maxBodySize 个字节，并且会脱敏
+type AccessLogMiddlewareBuilder struct {
+	l             logger.LoggerV1
+	allowReqBody  bool
+	allowRespBody bool
+	allowHeaders  bool
+	maxBodySize   int
+	redactKeys    []string
+}
+
+func NewAccessLogMiddlewareBuilder(l logger.LoggerV1) *AccessLogMiddlewareBuilder {
+	return &AccessLogMiddlewareBuilder{
+		l:           l,
+		maxBodySize: 1024,
+		redactKeys:  DefaultRedactKeys,
+	}
+}
+
+func (b *AccessLogMiddlewareBuilder) AllowReqBody() *AccessLogMiddlewareBuilder {
+	b.allowReqBody = true
+	return b
+}
+
+func (b *AccessLogMiddlewareBuilder) AllowRespBody() *AccessLogMiddlewareBuilder {
+	b.allowRespBody = true
+	return b
+}
+
+// AllowHeaders 打印请求头和响应头
+func (b *AccessLogMiddlewareBuilder) AllowHeaders() *AccessLogMiddlewareBuilder {
+	b.allowHeaders = true
+	return b
+}
+
+// MaxBodySize 请求体、响应体最多打这么多字节，超过的截断
+func (b *AccessLogMiddlewareBuilder) MaxBodySize(n int) *AccessLogMiddlewareBuilder {
+	b.maxBodySize = n
+	return b
+}
+
+// Redact 替换掉要脱敏的字段，不区分大小写
+func (b *AccessLogMiddlewareBuilder) Redact(keys ...string) *AccessLogMiddlewareBuilder {
+	b.redactKeys = keys
+	return b
+}
+
+func (b *AccessLogMiddlewareBuilder) Build() gin.HandlerFunc {
+	redactor := newRedactor(b.redactKeys)
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		var reqBody []byte
+		if b.allowReqBody && ctx.Request.Body != nil {
+			// 只读前面一段，剩下的原样接在后面，上传大文件的时候不会整个读进内存
+			reqBody, _ = io.ReadAll(io.LimitReader(ctx.Request.Body, int64(b.maxBodySize)))
+			ctx.Request.Body = readCloser{
+				Reader: io.MultiReader(bytes.NewReader(reqBody), ctx.Request.Body),
+				Closer: ctx.Request.Body,
+			}
+		}
+		var resp *bodyCaptureWriter
+		if b.allowRespBody {
+			resp = &bodyCaptureWriter{ResponseWriter: ctx.Writer, limit: b.maxBodySize}
+			ctx.Writer = resp
+		}
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		fields := []logger.Field{
+			logger.String("method", ctx.Request.Method),
+			logger.String("path", ctx.Request.URL.Path),
+			logger.Int("status", status),
+			logger.Duration("latency", time.Since(start)),
+			logger.Int64("req_size", max(ctx.Request.ContentLength, 0)),
+			logger.Int("resp_size", max(ctx.Writer.Size(), 0)),
+		}
+		if b.allowHeaders {
+			fields = append(fields,
+				logger.Any("req_headers", redactor.headers(ctx.Request.Header)),
+				logger.Any("resp_headers", redactor.headers(ctx.Writer.Header())))
+		}
+		if b.allowReqBody {
+			fields = append(fields, logger.String("req_body",
+				redactor.body(ctx.Request.Header.Get("Content-Type"), reqBody)))
+		}
+		if resp != nil {
+			fields = append(fields, logger.String("resp_body",
+				redactor.body(ctx.Writer.Header().Get("Content-Type"), resp.body.Bytes())))
+		}
+		// 后面的 RequestLogMiddlewareBuilder 会换掉 ctx.Request，里面有带 request id 的 logger
+		l := logger.FromContextOr(ctx.Request.Context(), b.l)
+		if status >= http.StatusInternalServerError {
+			l.Error("访问日志", fields...)
+			return
+		}
+		l.Info("访问日志", fields...)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyCaptureWriter 照常写响应，顺便记下前面 limit 个字节
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+func (w *bodyCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *bodyCaptureWriter) capture(data []byte) {
+	if left := w.limit - w.body.Len(); left > 0 {
+		w.body.Write(data[:min(left, len(data))])
+	}
+}
+
+type redactor struct {
+	keys map[string]struct{}
+	// 匹配 "key": value，字符串的 value 可能被截断了没有右引号
+	jsonRe *regexp.Regexp
+}
+
+func newRedactor(keys []string) redactor {
+	r := redactor{keys: make(map[string]struct{}, len(keys))}
+	if len(keys) == 0 {
+		return r
+	}
+	quoted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		r.keys[strings.ToLower(k)] = struct{}{}
+		quoted = append(quoted, regexp.QuoteMeta(k))
+	}
+	r.jsonRe = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)(?:"(?:[^"\\]|\\.)*(?:"|\\?$)|[^"\s,}\]][^,}\]]*)`)
+	return r
+}
+
+func (r redactor) headers(h http.Header) map[string]string {
+	res := make(map[string]string, len(h))
+	for k, vs := range h {
+		if _, ok := r.keys[strings.ToLower(k)]; ok {
+			res[k] = redacted
+			continue
+		}
+		res[k] = strings.Join(vs, ",")
+	}
+	return res
+}
+
+// body JSON 按照字段脱敏。别的类型不知道里面有什么，比如上传的文件，只打长度
+func (r redactor) body(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !strings.Contains(contentType, "json") {
+		return "<" + contentType + ", " + strconv.Itoa(len(body)) + " bytes>"
+	}
+	if r.jsonRe == nil {
+		return string(body)
+	}
+	return r.jsonRe.ReplaceAllString(string(body), `${1}"`+redacted+`"`)
+}
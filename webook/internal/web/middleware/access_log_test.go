@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"gochuji/webook/pkg/logger"
+)
+
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.DebugLevel)
+	l := logger.NewZapLogger(zap.New(core))
+
+	server := gin.New()
+	server.Use(NewAccessLogMiddlewareBuilder(l).
+		AllowReqBody().AllowRespBody().AllowHeaders().
+		MaxBodySize(64).Build())
+	server.Use(NewRequestLogMiddlewareBuilder(l).Build())
+	var got string
+	server.POST("/users/login", func(ctx *gin.Context) {
+		// 业务代码还能读到完整的请求体
+		body, _ := io.ReadAll(ctx.Request.Body)
+		got = string(body)
+		ctx.Header("x-jwt-token", "token")
+		ctx.JSON(http.StatusOK, gin.H{"msg": "ok"})
+	})
+
+	reqBody := `{"email":"a@b.com","password":"hello#123","confirmPassword":"hello#123","aboutMe":"` +
+		strings.Repeat("x", 100) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/users/login", strings.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, reqBody, got)
+
+	entries := logs.FilterMessage("访问日志").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "POST", fields["method"])
+	assert.Equal(t, "/users/login", fields["path"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Equal(t, int64(len(reqBody)), fields["req_size"])
+	assert.Equal(t, int64(len(`{"msg":"ok"}`)), fields["resp_size"])
+	// 后面的中间件放的 request id 也带上了
+	assert.Equal(t, recorder.Header().Get(HeaderRequestID), fields["request_id"])
+	// 截断到 64 个字节，并且脱敏
+	assert.Equal(t, `{"email":"a@b.com","password":"***","confirmPassword":"***"`, fields["req_body"])
+	assert.Equal(t, `{"msg":"ok"}`, fields["resp_body"])
+	assert.Equal(t, "***", fields["req_headers"].(map[string]string)["Authorization"])
+	assert.Equal(t, "***", fields["resp_headers"].(map[string]string)["X-Jwt-Token"])
+}
+
+func TestRedactor_Body(t *testing.T) {
+	r := newRedactor(DefaultRedactKeys)
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "嵌套和大小写",
+			contentType: "application/json",
+			body:        `{"user":{"Password" : "a\"b","age":18}}`,
+			want:        `{"user":{"Password" : "***","age":18}}`,
+		},
+		{
+			name:        "截断在密码中间",
+			contentType: "application/json",
+			body:        `{"password":"hello\`,
+			want:        `{"password":"***"`,
+		},
+		{
+			name:        "不是字符串",
+			contentType: "application/json",
+			body:        `{"password":123456,"a":1}`,
+			want:        `{"password":"***","a":1}`,
+		},
+		{
+			name:        "不是 JSON",
+			contentType: "multipart/form-data",
+			body:        "password=123",
+			want:        "<multipart/form-data, 12 bytes>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, r.body(tc.contentType, []byte(tc.body)))
+		})
+	}
+}
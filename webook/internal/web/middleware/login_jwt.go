@@ -4,7 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"gochuji/webook/internal/web"
-	"log"
+	"gochuji/webook/pkg/logger"
 	"net/http"
 	"strings"
 	"time"
@@ -20,6 +20,15 @@ func (m *LoginJWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 			// 不需要登录校验
 			return
 		}
+		// 头像之类的静态文件谁都可以看
+		if strings.HasPrefix(path, "/static/") {
+			return
+		}
+		// 公开的主页，用路由模板来判断
+		switch ctx.FullPath() {
+		case "/users/:id/profile", "/users/lookup":
+			return
+		}
 		// 根据约定，token 在 Authorization 头部
 		// Bearer XXXX
 		authCode := ctx.GetHeader("Authorization")
@@ -63,9 +72,12 @@ func (m *LoginJWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 			ctx.Header("x-jwt-token", tokenStr)
 			if err != nil {
 				// 这边不要中断，因为仅仅是过期时间没有刷新，但是用户是登录了的
-				log.Println(err)
+				logger.FromContext(ctx).Warn("刷新 token 失败", logger.Int64("uid", uc.Uid), logger.Error(err))
 			}
 		}
 		ctx.Set("user", uc)
+		// 后面的日志都带上 uid
+		l := logger.FromContext(ctx.Request.Context()).With(logger.Int64("uid", uc.Uid))
+		ctx.Request = ctx.Request.WithContext(logger.WithContext(ctx.Request.Context(), l))
 	}
 }
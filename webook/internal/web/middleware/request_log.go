@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+
+	"gochuji/webook/pkg/logger"
+)
+
+const HeaderRequestID = "X-Request-Id"
+
+// maxRequestIDLen 客户端传过来的 request id 最长这么多，再长就自己生成一个
+const maxRequestIDLen = 64
+
+// RequestLogMiddlewareBuilder 给每个请求准备一个带上 request id、路由和 trace id 的 logger，
+// 后面用 logger.FromContext(ctx) 拿到。要放在登录校验前面，被拦下来的请求也有 request id，
+// 登录校验通过之后会在这个 logger 上加上 uid
+type RequestLogMiddlewareBuilder struct {
+	l logger.LoggerV1
+}
+
+func NewRequestLogMiddlewareBuilder(l logger.LoggerV1) *RequestLogMiddlewareBuilder {
+	return &RequestLogMiddlewareBuilder{l: l}
+}
+
+func (b *RequestLogMiddlewareBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// 网关传过来的就沿用，这样能和上游的日志对上。
+		// 这个头客户端也能随便填，太长或者有奇怪字符的就不用了，免得污染日志
+		reqID := ctx.GetHeader(HeaderRequestID)
+		if !validRequestID(reqID) {
+			reqID = newRequestID()
+		}
+		ctx.Header(HeaderRequestID, reqID)
+
+		fields := []logger.Field{
+			logger.String("request_id", reqID),
+			logger.String("route", ctx.FullPath()),
+		}
+		if sc := trace.SpanContextFromContext(ctx.Request.Context()); sc.IsValid() {
+			fields = append(fields, logger.String("trace_id", sc.TraceID().String()))
+		}
+		reqCtx := logger.WithContext(ctx.Request.Context(), b.l.With(fields...))
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		ctx.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID 只接受字母、数字和 . _ -
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"gochuji/webook/internal/web"
+	"gochuji/webook/pkg/logger"
+)
+
+func TestRequestLog_RequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testCases := []struct {
+		name   string
+		header string
+		// 为空表示要重新生成
+		want string
+	}{
+		{name: "沿用网关的", header: "gw-123_abc.1", want: "gw-123_abc.1"},
+		{name: "没有传", header: ""},
+		{name: "太长", header: strings.Repeat("a", maxRequestIDLen+1)},
+		{name: "有换行", header: "abc\ninjected"},
+		{name: "有空格和引号", header: `abc "x"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := gin.New()
+			server.Use(NewRequestLogMiddlewareBuilder(logger.NewNopLogger()).Build())
+			server.GET("/ping", func(ctx *gin.Context) {})
+
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			req.Header[HeaderRequestID] = []string{tc.header}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			got := resp.Header().Get(HeaderRequestID)
+			if tc.want != "" {
+				assert.Equal(t, tc.want, got)
+				return
+			}
+			assert.Len(t, got, 32)
+			assert.True(t, validRequestID(got))
+		})
+	}
+}
+
+func TestRequestLog_CheckLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.DebugLevel)
+	l := logger.NewZapLogger(zap.New(core))
+	// 和 main.go 里面的顺序一样
+	server := gin.New()
+	server.ContextWithFallback = true
+	server.Use(NewAccessLogMiddlewareBuilder(l).Build())
+	server.Use(NewRequestLogMiddlewareBuilder(l).Build())
+	login := LoginJWTMiddlewareBuilder{}
+	server.Use(login.CheckLogin())
+	server.GET("/users/profile", func(ctx *gin.Context) {
+		logger.FromContext(ctx).Info("业务日志")
+	})
+
+	// 没登录被拦下来，也有 request id 和访问日志
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/users/profile", nil))
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	reqID := resp.Header().Get(HeaderRequestID)
+	assert.NotEmpty(t, reqID)
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	assert.Equal(t, reqID, entries[0].ContextMap()["request_id"])
+
+	// 登录了的请求，后面的日志都带上 uid
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, web.UserClaims{
+		Uid:              123,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	}).SignedString(web.JWTKey)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	entries = logs.TakeAll()
+	require.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.Equal(t, int64(123), e.ContextMap()["uid"], e.Message)
+		assert.Equal(t, resp.Header().Get(HeaderRequestID), e.ContextMap()["request_id"], e.Message)
+	}
+}
@@ -0,0 +1,18 @@
+package web
+
+// Result 统一的 JSON 返回结构
+type Result struct {
+	// 业务错误码，0 代表成功
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data any    `json:"data"`
+}
+
+const (
+	// codeInvalidParam 请求参数校验不通过，Data 里面是 []FieldError
+	codeInvalidParam = 4
+	// codeSystemError 系统错误，Msg 里面是给用户看的提示
+	codeSystemError = 5
+	// codeInvalidAvatar 头像文件不合法，Msg 里面是具体的原因
+	codeInvalidAvatar = 6
+)
@@ -1,24 +1,32 @@
 package web
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
-	regexp "github.com/dlclark/regexp2"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	jwt "github.com/golang-jwt/jwt/v5"
 
 	"gochuji/webook/internal/domain"
 	"gochuji/webook/internal/service"
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/password"
 )
 
 type UserHandler struct {
-	svc         *service.UserService
-	emailExp    *regexp.Regexp
-	passwordExp *regexp.Regexp
+	svc       *service.UserService
+	avatarSvc *service.AvatarService
+	deviceSvc *service.DeviceService
 }
 
+// DeviceIDHeader 前端生成并保存在本地的设备 id
+const DeviceIDHeader = "X-Device-Id"
+
 var JWTKey = []byte("k6CswdUm77WKcbM68UQUuxVsHSpTCwgK")
 
 type UserClaims struct {
@@ -26,18 +34,16 @@ type UserClaims struct {
 	Uid int64
 }
 
-func NewUserHandler(svc *service.UserService) *UserHandler {
-	const (
-		emailRegexPattern = "^\\w+([-+.]\\w+)*@\\w+([-.]\\w+)*\\.\\w+([-.]\\w+)*$"
-		// 和上面比起来，用 ` 看起来就比较清爽
-		passwordRegexPattern = `^(?=.*[A-Za-z])(?=.*\d)(?=.*[$@$!%*#?&])[A-Za-z\d$@$!%*#?&]{8,}$`
-	)
-	emailExp := regexp.MustCompile(emailRegexPattern, regexp.None)
-	passwordExp := regexp.MustCompile(passwordRegexPattern, regexp.None)
+func NewUserHandler(svc *service.UserService, avatarSvc *service.AvatarService,
+	deviceSvc *service.DeviceService, policy *password.Policy) *UserHandler {
+	// 注册失败说明 gin 换了校验引擎，启动的时候就应该暴露出来
+	if err := InitValidator(policy); err != nil {
+		panic(err)
+	}
 	return &UserHandler{
-		svc:         svc,
-		emailExp:    emailExp,
-		passwordExp: passwordExp,
+		svc:       svc,
+		avatarSvc: avatarSvc,
+		deviceSvc: deviceSvc,
 	}
 }
 
@@ -61,54 +67,49 @@ func (h *UserHandler) RegisterRoutes(server *gin.Engine) {
 
 	// GET /users/profile
 	ug.GET("/profile", h.JWTProfile)
+
+	// POST /users/avatar
+	ug.POST("/avatar", h.UploadAvatar)
+
+	// POST /users/privacy
+	ug.POST("/privacy", h.EditPrivacy)
+
+	// 下面两个是公开的，不需要登录
+	// GET /users/123/profile
+	ug.GET("/:id/profile", h.PublicProfile)
+	// GET /users/lookup?nickname=xxx
+	ug.GET("/lookup", h.Lookup)
 }
 
 func (h *UserHandler) SignUp(ctx *gin.Context) {
 	type SignUpReq struct {
-		Email           string `json:"email"`
-		Password        string `json:"password"`
-		ConfirmPassword string `json:"confirmPassword"`
+		Email           string `json:"email" binding:"required,email"`
+		Password        string `json:"password" binding:"required,password"`
+		ConfirmPassword string `json:"confirmPassword" binding:"required,eqfield=Password"`
 	}
 
 	var req SignUpReq
-	if err := ctx.Bind(&req); err != nil {
-		return
-	}
-
-	isEmail, err := h.emailExp.MatchString(req.Email)
-	if err != nil {
-		ctx.String(http.StatusOK, "系统错误")
-		return
-	}
-	if !isEmail {
-		ctx.String(http.StatusOK, "非法邮箱格式")
+	if !bindAndValidate(ctx, &req) {
 		return
 	}
 
-	if req.Password != req.ConfirmPassword {
-		ctx.String(http.StatusOK, "两次输入密码不对")
-		return
-	}
-
-	isPassword, err := h.passwordExp.MatchString(req.Password)
-	if err != nil {
-		ctx.String(http.StatusOK, "系统错误")
-		return
-	}
-	if !isPassword {
-		ctx.String(http.StatusOK, "密码必须包含字母、数字、特殊字符，并且不少于八位")
-		return
-	}
-
-	err = h.svc.Signup(ctx, domain.User{
+	err := h.svc.Signup(ctx, domain.User{
 		Email:    req.Email,
 		Password: req.Password,
 	})
-	switch err {
-	case nil:
+	switch {
+	case err == nil:
 		ctx.String(http.StatusOK, "注册成功")
-	case service.ErrDuplicateEmail:
+	case errors.Is(err, service.ErrDuplicateEmail):
 		ctx.String(http.StatusOK, "邮箱冲突，请换一个")
+	case errors.Is(err, password.ErrBreached):
+		ctx.String(http.StatusOK, "这个密码已经泄露过，请换一个")
+	case errors.Is(err, password.ErrBlocked):
+		ctx.String(http.StatusOK, "这个密码太常见了，请换一个")
+	case errors.Is(err, password.ErrTooShort), errors.Is(err, password.ErrTooLong),
+		errors.Is(err, password.ErrMissingClass):
+		// 一般在参数校验的时候就拦住了，这里兜底
+		ctx.String(http.StatusOK, passwordRuleDesc)
 	default:
 		ctx.String(http.StatusOK, "系统错误")
 	}
@@ -116,12 +117,11 @@ func (h *UserHandler) SignUp(ctx *gin.Context) {
 
 func (h *UserHandler) Login(ctx *gin.Context) {
 	type Req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
 	}
 	var req Req
-	if err := ctx.Bind(&req); err != nil {
-		ctx.String(http.StatusOK, "系统错误")
+	if !bindAndValidate(ctx, &req) {
 		return
 	}
 	u, err := h.svc.Login(ctx, req.Email, req.Password)
@@ -162,13 +162,14 @@ func (h *UserHandler) Login(ctx *gin.Context) {
 
 func (h *UserHandler) JWTLogin(ctx *gin.Context) {
 	type Req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+		// 勾选了"信任此设备"，在这个设备上查看自己的资料就不打码
+		TrustDevice bool `json:"trustDevice"`
 	}
 
 	var req Req
-	if err := ctx.Bind(&req); err != nil {
-		ctx.String(http.StatusOK, "系统错误")
+	if !bindAndValidate(ctx, &req) {
 		return
 	}
 	u, err := h.svc.Login(ctx, req.Email, req.Password)
@@ -194,6 +195,13 @@ func (h *UserHandler) JWTLogin(ctx *gin.Context) {
 		}
 		// ■ ■ 将JWT添加到响应头中
 		ctx.Header("x-jwt-token", tokenStr)
+		if req.TrustDevice {
+			err = h.deviceSvc.Trust(ctx, u.Id, ctx.GetHeader(DeviceIDHeader))
+			if err != nil {
+				// 记不住设备只是多打几次码，不影响登录
+				logger.FromContext(ctx).Warn("记录信任设备失败", logger.Int64("uid", u.Id), logger.Error(err))
+			}
+		}
 		// 返回登录成功
 		ctx.String(http.StatusOK, "登录成功")
 
@@ -208,13 +216,13 @@ func (h *UserHandler) JWTEdit(ctx *gin.Context) {
 	//{"nickname":"huali","birthday":"2025-07-28","aboutMe":"sdfsfdfsfdfsdghhhh"}
 	//处理传入的json
 	type Req struct {
-		Nickname string `json:"nickname"`
-		Birthday string `json:"birthday"`
-		AboutMe  string `json:"aboutMe"`
+		// 没传的字段保持原样，传了空字符串就是清空
+		Nickname *string `json:"nickname" binding:"omitempty,max=50"`
+		Birthday *string `json:"birthday" binding:"omitempty,birthday"`
+		AboutMe  *string `json:"aboutMe" binding:"omitempty,max=500"`
 	}
 	var req Req
-	if err := ctx.Bind(&req); err != nil {
-		ctx.String(http.StatusOK, "系统错误")
+	if !bindAndValidate(ctx, &req) {
 		return
 	}
 
@@ -226,19 +234,23 @@ func (h *UserHandler) JWTEdit(ctx *gin.Context) {
 		return
 	}
 
-	birthday, err := time.Parse(time.DateOnly, req.Birthday)
-	if err != nil {
-		//ctx.String(http.StatusOK, "系统错误")
-		ctx.String(http.StatusOK, "生日格式不对")
-		return
+	patch := domain.ProfilePatch{Nickname: req.Nickname, AboutMe: req.AboutMe}
+	if req.Birthday != nil {
+		// 空字符串是清空生日，零值的 time.Time
+		var birthday time.Time
+		if *req.Birthday != "" {
+			var err error
+			birthday, err = time.Parse(time.DateOnly, *req.Birthday)
+			if err != nil {
+				//ctx.String(http.StatusOK, "系统错误")
+				ctx.String(http.StatusOK, "生日格式不对")
+				return
+			}
+		}
+		patch.Birthday = &birthday
 	}
 
-	err = h.svc.UpdateNonSensitiveInfo(ctx, domain.User{
-		Id:       uc.Uid,
-		Nickname: req.Nickname,
-		Birthday: birthday,
-		AboutMe:  req.AboutMe,
-	})
+	err := h.svc.UpdateNonSensitiveInfo(ctx, uc.Uid, patch)
 	if err != nil {
 		ctx.String(http.StatusOK, "系统异常")
 		return
@@ -261,13 +273,19 @@ func (h *UserHandler) JWTProfile(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "系统异常")
 	}
 
+	email, phone := u.Email, u.Phone
+	// 在不信任的设备上，自己的邮箱和手机号也要打码
+	if !h.deviceSvc.IsTrusted(ctx, uc.Uid, ctx.GetHeader(DeviceIDHeader)) {
+		email, phone = maskEmail(email), maskPhone(phone)
+	}
 	profile := &Profile{
-		Email:    u.Email,
-		Phone:    u.Phone,
+		Email:    email,
+		Phone:    phone,
 		Nickname: u.Nickname,
 		//
 		Birthday: u.Birthday.Format(time.DateOnly),
 		AboutMe:  u.AboutMe,
+		Avatar:   h.avatarSvc.URL(u.Avatar, service.AvatarSizes[len(service.AvatarSizes)-1]),
 	}
 
 	// 返回JSON响应
@@ -280,4 +298,147 @@ type Profile struct {
 	Nickname string `json:"Nickname"`
 	Birthday string `json:"Birthday"`
 	AboutMe  string `json:"AboutMe"`
+	Avatar   string `json:"Avatar"`
+}
+
+// PublicProfile 别人看到的主页，只返回公开的字段
+type PublicProfile struct {
+	Id       int64  `json:"id"`
+	Nickname string `json:"nickname"`
+	AboutMe  string `json:"aboutMe,omitempty"`
+	Avatar   string `json:"avatar,omitempty"`
+	// 注册日期，YYYY-MM-DD
+	JoinDate string `json:"joinDate,omitempty"`
+}
+
+func (h *UserHandler) toPublicProfile(u domain.User) PublicProfile {
+	res := PublicProfile{
+		Id:       u.Id,
+		Nickname: u.Nickname,
+		AboutMe:  u.AboutMe,
+		Avatar:   h.avatarSvc.URL(u.Avatar, service.AvatarSizes[len(service.AvatarSizes)-1]),
+	}
+	if !u.Ctime.IsZero() {
+		res.JoinDate = u.Ctime.Format(time.DateOnly)
+	}
+	return res
+}
+
+func (h *UserHandler) PublicProfile(ctx *gin.Context) {
+	uid, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil || uid <= 0 {
+		ctx.String(http.StatusOK, "用户不存在")
+		return
+	}
+	u, err := h.svc.FindPublicByID(ctx, uid)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{Data: h.toPublicProfile(u)})
+	case service.ErrUserNotFound:
+		ctx.String(http.StatusOK, "用户不存在")
+	default:
+		ctx.String(http.StatusOK, "系统错误")
+	}
+}
+
+func (h *UserHandler) Lookup(ctx *gin.Context) {
+	type Req struct {
+		Nickname string `form:"nickname" json:"nickname" binding:"required,max=50"`
+	}
+	var req Req
+	if !bindAndValidate(ctx, &req) {
+		return
+	}
+	const limit = 20
+	us, err := h.svc.FindPublicByNickname(ctx, req.Nickname, limit)
+	if err != nil {
+		ctx.String(http.StatusOK, "系统错误")
+		return
+	}
+	res := make([]PublicProfile, 0, len(us))
+	for _, u := range us {
+		res = append(res, h.toPublicProfile(u))
+	}
+	ctx.JSON(http.StatusOK, Result{Data: res})
+}
+
+func (h *UserHandler) EditPrivacy(ctx *gin.Context) {
+	type Req struct {
+		HideAboutMe  bool `json:"hideAboutMe"`
+		HideAvatar   bool `json:"hideAvatar"`
+		HideJoinDate bool `json:"hideJoinDate"`
+	}
+	var req Req
+	if !bindAndValidate(ctx, &req) {
+		return
+	}
+	uc, ok := ctx.MustGet("user").(UserClaims)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	err := h.svc.UpdatePrivacy(ctx, uc.Uid, domain.Privacy{
+		HideAboutMe:  req.HideAboutMe,
+		HideAvatar:   req.HideAvatar,
+		HideJoinDate: req.HideJoinDate,
+	})
+	if err != nil {
+		ctx.String(http.StatusOK, "系统异常")
+		return
+	}
+	ctx.String(http.StatusOK, "更新成功")
+}
+
+// UploadAvatar 上传头像，multipart 表单里面的字段名是 avatar
+func (h *UserHandler) UploadAvatar(ctx *gin.Context) {
+	uc, ok := ctx.MustGet("user").(UserClaims)
+	if !ok {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// 多留 1MB 给 multipart 的其它部分，超过了直接读失败，不会把大文件读进内存
+	maxSize := h.avatarSvc.MaxSize()
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize+1<<20)
+	fh, err := ctx.FormFile("avatar")
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: codeInvalidAvatar, Msg: fmt.Sprintf("请选择头像文件，并且不能超过 %dKB", maxSize>>10)})
+		return
+	}
+	if fh.Size > maxSize {
+		ctx.JSON(http.StatusOK, Result{Code: codeInvalidAvatar, Msg: fmt.Sprintf("头像不能超过 %dKB", maxSize>>10)})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: codeSystemError, Msg: "系统错误"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		ctx.JSON(http.StatusOK, Result{Code: codeSystemError, Msg: "系统错误"})
+		return
+	}
+
+	avatar, err := h.avatarSvc.Upload(ctx, uc.Uid, data)
+	switch err {
+	case nil:
+		urls := make(map[string]string, len(service.AvatarSizes))
+		for _, size := range service.AvatarSizes {
+			urls[strconv.Itoa(size)] = h.avatarSvc.URL(avatar, size)
+		}
+		ctx.JSON(http.StatusOK, Result{
+			Msg:  "上传成功",
+			Data: urls,
+		})
+	case service.ErrAvatarTooLarge:
+		ctx.JSON(http.StatusOK, Result{Code: codeInvalidAvatar, Msg: fmt.Sprintf("头像不能超过 %dKB", maxSize>>10)})
+	case service.ErrAvatarUnsupported:
+		ctx.JSON(http.StatusOK, Result{Code: codeInvalidAvatar, Msg: "只支持 JPEG、PNG、GIF 和 WebP 格式的图片"})
+	case service.ErrAvatarDimension:
+		ctx.JSON(http.StatusOK, Result{Code: codeInvalidAvatar, Msg: "头像的长宽必须在 32 到 4096 像素之间"})
+	default:
+		ctx.JSON(http.StatusOK, Result{Code: codeSystemError, Msg: "系统错误"})
+	}
 }
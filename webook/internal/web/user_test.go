@@ -0,0 +1,377 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"gochuji/webook/internal/domain"
+	"gochuji/webook/internal/repository"
+	"gochuji/webook/internal/repository/cache"
+	"gochuji/webook/internal/repository/dao"
+	"gochuji/webook/internal/service"
+	"gochuji/webook/pkg/password"
+	"gochuji/webook/pkg/storage"
+)
+
+// testEnv 用 sqlmock 和 miniredis 搭起来的 UserHandler，数据库和 Redis 都不用真的起
+type testEnv struct {
+	server  *gin.Engine
+	mock    sqlmock.Sqlmock
+	rdb     redis.Cmdable
+	storage *storage.LocalStorage
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	gin.SetMode(gin.TestMode)
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	ur := repository.NewUserRepository(dao.NewUserDAO(db), cache.NewUserCache(rdb))
+	policy := password.DefaultPolicy()
+	us := service.NewUserService(ur, policy, password.NewBcryptHasher(bcrypt.MinCost))
+	st := storage.NewLocalStorage(t.TempDir(), "http://localhost/static")
+	as := service.NewAvatarService(ur, st, 2<<20)
+	ds := service.NewDeviceService(repository.NewDeviceRepository(cache.NewDeviceCache(rdb)))
+	hdl := NewUserHandler(us, as, ds, policy)
+
+	server := gin.New()
+	// 代替登录校验，X-Uid 就是登录的用户
+	server.Use(func(ctx *gin.Context) {
+		if uid := ctx.GetHeader("X-Uid"); uid != "" {
+			var uc UserClaims
+			_ = json.Unmarshal([]byte(uid), &uc.Uid)
+			ctx.Set("user", uc)
+		}
+	})
+	hdl.RegisterRoutes(server)
+	return &testEnv{server: server, mock: mock, rdb: rdb, storage: st}
+}
+
+func (e *testEnv) do(t *testing.T, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	e.server.ServeHTTP(recorder, req)
+	require.NoError(t, e.mock.ExpectationsWereMet())
+	return recorder
+}
+
+var testUser = domain.User{
+	Id:       1,
+	Email:    "abcdef@qq.com",
+	Phone:    "13812345678",
+	Nickname: "花里",
+	Birthday: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+	AboutMe:  "hello",
+	Avatar:   "avatars/1/abc",
+	Ctime:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func TestUserHandler_PublicProfile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		uid     string
+		privacy domain.Privacy
+		want    string
+	}{
+		{
+			name: "全部公开",
+			uid:  "1",
+			want: `{"code":0,"msg":"","data":{"id":1,"nickname":"花里","aboutMe":"hello",
+				"avatar":"http://localhost/static/avatars/1/abc_256.jpg","joinDate":"2024-05-01"}}`,
+		},
+		{
+			name:    "隐藏了简介和注册日期",
+			uid:     "1",
+			privacy: domain.Privacy{HideAboutMe: true, HideJoinDate: true},
+			want: `{"code":0,"msg":"","data":{"id":1,"nickname":"花里",
+				"avatar":"http://localhost/static/avatars/1/abc_256.jpg"}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			u := testUser
+			u.Privacy = tc.privacy
+			require.NoError(t, cache.NewUserCache(env.rdb).Set(context.Background(), u))
+
+			resp := env.do(t, httptest.NewRequest(http.MethodGet, "/users/"+tc.uid+"/profile", nil))
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.want, resp.Body.String())
+			// 邮箱、手机号从来不公开
+			assert.NotContains(t, resp.Body.String(), "qq.com")
+			assert.NotContains(t, resp.Body.String(), "138")
+		})
+	}
+
+	t.Run("id 不合法", func(t *testing.T) {
+		env := newTestEnv(t)
+		resp := env.do(t, httptest.NewRequest(http.MethodGet, "/users/abc/profile", nil))
+		assert.Equal(t, "用户不存在", resp.Body.String())
+	})
+}
+
+func TestUserHandler_Lookup(t *testing.T) {
+	env := newTestEnv(t)
+	rows := sqlmock.NewRows([]string{"id", "email", "phone", "nickname", "about_me", "avatar", "ctime", "privacy"}).
+		AddRow(1, "a@qq.com", "13812345678", "花里", "hello", "", testUser.Ctime.UnixMilli(), 0).
+		// 第二个同名用户隐藏了简介和注册日期
+		AddRow(2, "b@qq.com", "13912345678", "花里", "secret", "", testUser.Ctime.UnixMilli(), 0b101)
+	env.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE nickname = ? ORDER BY id LIMIT ?")).
+		WithArgs("花里", 20).WillReturnRows(rows)
+
+	resp := env.do(t, httptest.NewRequest(http.MethodGet, "/users/lookup?nickname="+url.QueryEscape("花里"), nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"code":0,"msg":"","data":[
+		{"id":1,"nickname":"花里","aboutMe":"hello","joinDate":"2024-05-01"},
+		{"id":2,"nickname":"花里"}]}`, resp.Body.String())
+
+	// 没有昵称直接拒绝，不查数据库
+	resp = env.do(t, httptest.NewRequest(http.MethodGet, "/users/lookup", nil))
+	var res Result
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	assert.Equal(t, codeInvalidParam, res.Code)
+}
+
+func TestUserHandler_JWTProfileMask(t *testing.T) {
+	testCases := []struct {
+		name      string
+		deviceID  string
+		wantEmail string
+		wantPhone string
+	}{
+		{
+			name:      "没有设备 id，打码",
+			wantEmail: "a****f@qq.com",
+			wantPhone: "138****5678",
+		},
+		{
+			name:      "不信任的设备，打码",
+			deviceID:  "unknown",
+			wantEmail: "a****f@qq.com",
+			wantPhone: "138****5678",
+		},
+		{
+			name:      "信任的设备，不打码",
+			deviceID:  "dev-1",
+			wantEmail: "abcdef@qq.com",
+			wantPhone: "13812345678",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := context.Background()
+			require.NoError(t, cache.NewUserCache(env.rdb).Set(ctx, testUser))
+			require.NoError(t, cache.NewDeviceCache(env.rdb).Add(ctx, testUser.Id, "dev-1"))
+
+			req := httptest.NewRequest(http.MethodGet, "/users/profile", nil)
+			req.Header.Set("X-Uid", "1")
+			if tc.deviceID != "" {
+				req.Header.Set(DeviceIDHeader, tc.deviceID)
+			}
+			resp := env.do(t, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+			var profile Profile
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &profile))
+			assert.Equal(t, tc.wantEmail, profile.Email)
+			assert.Equal(t, tc.wantPhone, profile.Phone)
+		})
+	}
+}
+
+func newAvatarRequest(t *testing.T, field string, data []byte) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile(field, "avatar.png")
+	require.NoError(t, err)
+	_, err = fw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	req := httptest.NewRequest(http.MethodPost, "/users/avatar", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-Uid", "1")
+	return req
+}
+
+func TestUserHandler_UploadAvatar(t *testing.T) {
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 100, 100))))
+
+	testCases := []struct {
+		name     string
+		field    string
+		data     []byte
+		mock     func(mock sqlmock.Sqlmock)
+		wantCode int
+		wantMsg  string
+	}{
+		{
+			name:  "成功",
+			field: "avatar",
+			data:  img.Bytes(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE `users` SET").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantCode: 0,
+			wantMsg:  "上传成功",
+		},
+		{
+			name:     "没有文件",
+			field:    "file",
+			data:     img.Bytes(),
+			wantCode: codeInvalidAvatar,
+			wantMsg:  "请选择头像文件，并且不能超过 2048KB",
+		},
+		{
+			name:     "太大",
+			field:    "avatar",
+			data:     make([]byte, 2<<20+1),
+			wantCode: codeInvalidAvatar,
+			wantMsg:  "头像不能超过 2048KB",
+		},
+		{
+			name:     "不是图片",
+			field:    "avatar",
+			data:     []byte("hello"),
+			wantCode: codeInvalidAvatar,
+			wantMsg:  "只支持 JPEG、PNG、GIF 和 WebP 格式的图片",
+		},
+		{
+			name:  "数据库出错",
+			field: "avatar",
+			data:  img.Bytes(),
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE `users` SET").WillReturnError(context.DeadlineExceeded)
+			},
+			wantCode: codeSystemError,
+			wantMsg:  "系统错误",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			require.NoError(t, cache.NewUserCache(env.rdb).Set(context.Background(), domain.User{Id: 1}))
+			if tc.mock != nil {
+				tc.mock(env.mock)
+			}
+
+			resp := env.do(t, newAvatarRequest(t, tc.field, tc.data))
+			require.Equal(t, http.StatusOK, resp.Code)
+			// 成功失败都是 JSON
+			var res struct {
+				Code int               `json:"code"`
+				Msg  string            `json:"msg"`
+				Data map[string]string `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+			assert.Equal(t, tc.wantCode, res.Code)
+			assert.Equal(t, tc.wantMsg, res.Msg)
+			if tc.wantCode == 0 {
+				assert.Len(t, res.Data, 3)
+				assert.Regexp(t, `^http://localhost/static/avatars/1/\d+_64\.jpg$`, res.Data["64"])
+			}
+		})
+	}
+}
+
+func TestUserHandler_JWTEditPartial(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		// 只更新传了并且变了的字段，为空代表不更新也不写 outbox
+		wantSet string
+		wantMsg string
+	}{
+		{
+			name:    "只改昵称",
+			body:    `{"nickname":"新昵称"}`,
+			wantSet: "UPDATE `users` SET `nickname`=?,`utime`=? WHERE id = ?",
+			wantMsg: "更新成功",
+		},
+		{
+			name:    "只改生日",
+			body:    `{"birthday":"2000-01-01"}`,
+			wantSet: "UPDATE `users` SET `birthday`=?,`utime`=? WHERE id = ?",
+			wantMsg: "更新成功",
+		},
+		{
+			name:    "清空简介和生日",
+			body:    `{"aboutMe":"","birthday":""}`,
+			wantSet: "UPDATE `users` SET `about_me`=?,`birthday`=?,`utime`=? WHERE id = ?",
+			wantMsg: "更新成功",
+		},
+		{
+			name:    "和原来一样",
+			body:    `{"nickname":"老昵称"}`,
+			wantMsg: "更新成功",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			ctx := context.Background()
+			require.NoError(t, cache.NewUserCache(env.rdb).Set(ctx, testUser))
+			env.mock.ExpectBegin()
+			env.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users` WHERE id = ?") + ".*FOR UPDATE").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "nickname", "birthday", "about_me"}).
+					AddRow(1, "老昵称", time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), "简介"))
+			if tc.wantSet != "" {
+				env.mock.ExpectExec(regexp.QuoteMeta(tc.wantSet)).WillReturnResult(sqlmock.NewResult(0, 1))
+				env.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `outbox_messages`")).WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+			env.mock.ExpectCommit()
+
+			req := httptest.NewRequest(http.MethodPost, "/users/edit", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Uid", "1")
+			resp := env.do(t, req)
+			assert.Equal(t, tc.wantMsg, resp.Body.String())
+			require.NoError(t, env.mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("什么都没传", func(t *testing.T) {
+		env := newTestEnv(t)
+		req := httptest.NewRequest(http.MethodPost, "/users/edit", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Uid", "1")
+		// 不碰数据库
+		assert.Equal(t, "更新成功", env.do(t, req).Body.String())
+		require.NoError(t, env.mock.ExpectationsWereMet())
+	})
+
+	t.Run("生日格式不对", func(t *testing.T) {
+		env := newTestEnv(t)
+		req := httptest.NewRequest(http.MethodPost, "/users/edit", strings.NewReader(`{"birthday":"2000/01/01"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Uid", "1")
+		var res Result
+		require.NoError(t, json.Unmarshal(env.do(t, req).Body.Bytes(), &res))
+		assert.Equal(t, codeInvalidParam, res.Code)
+	})
+}
@@ -0,0 +1,133 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"gochuji/webook/pkg/password"
+)
+
+var (
+	// 生日允许的最早日期，最晚不能超过今天
+	minBirthday = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 校验失败的时候告诉用户密码策略是什么
+	passwordRuleDesc = "密码不符合要求"
+)
+
+// InitValidator 把自定义的校验规则注册到 gin 默认的 validator 上，
+// 之后所有请求结构体都可以通过 binding tag 使用这些规则。
+// 只应该在启动的时候调用
+func InitValidator(policy *password.Policy) error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("gin 的校验引擎不是 go-playground/validator")
+	}
+	passwordRuleDesc = policy.Describe()
+	return registerRules(v, policy)
+}
+
+func registerRules(v *validator.Validate, policy *password.Policy) error {
+	// 错误里面用 json 的字段名，前端才对得上
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name, _, _ := strings.Cut(fld.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return fld.Name
+		}
+		return name
+	})
+	// 这里只做格式校验，泄露库的检查放在 service 里面
+	err := v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return policy.CheckFormat(fl.Field().String()) == nil
+	})
+	if err != nil {
+		return err
+	}
+	return v.RegisterValidation("birthday", validateBirthday)
+}
+
+// validateBirthday 生日必须是 YYYY-MM-DD 格式，并且在 1900-01-01 到今天之间。
+// 空字符串代表清空生日，必须填的话再加上 required
+func validateBirthday(fl validator.FieldLevel) bool {
+	if fl.Field().String() == "" {
+		return true
+	}
+	birthday, err := time.Parse(time.DateOnly, fl.Field().String())
+	if err != nil {
+		return false
+	}
+	return !birthday.Before(minBirthday) && !birthday.After(time.Now())
+}
+
+// FieldError 单个字段的校验错误
+type FieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+// translateValidationErr 把 validator 的错误转成字段级别的错误。
+// 如果不是校验错误（比如 JSON 本身就是坏的），返回 nil
+func translateValidationErr(err error) []FieldError {
+	var ves validator.ValidationErrors
+	if !errors.As(err, &ves) {
+		return nil
+	}
+	res := make([]FieldError, 0, len(ves))
+	for _, fe := range ves {
+		res = append(res, FieldError{
+			Field: fe.Field(),
+			Msg:   fieldErrMsg(fe),
+		})
+	}
+	return res
+}
+
+func fieldErrMsg(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "不能为空"
+	case "email":
+		return "非法邮箱格式"
+	case "password":
+		return passwordRuleDesc
+	case "birthday":
+		return "生日格式不对，必须是 1900-01-01 到今天之间的 YYYY-MM-DD"
+	case "eqfield":
+		return "两次输入密码不对"
+	case "max":
+		return fmt.Sprintf("长度不能超过 %s", fe.Param())
+	case "min":
+		return fmt.Sprintf("长度不能少于 %s", fe.Param())
+	default:
+		return "格式不对"
+	}
+}
+
+// bindAndValidate 绑定请求并校验。返回 false 的时候响应已经写好了，调用方直接 return 就可以
+func bindAndValidate(ctx *gin.Context, req any) bool {
+	err := ctx.ShouldBind(req)
+	if err == nil {
+		return true
+	}
+	if fes := translateValidationErr(err); fes != nil {
+		ctx.JSON(http.StatusOK, Result{
+			Code: codeInvalidParam,
+			Msg:  "参数错误",
+			Data: fes,
+		})
+		return false
+	}
+	ctx.String(http.StatusOK, "系统错误")
+	return false
+}
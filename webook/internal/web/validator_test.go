@@ -0,0 +1,77 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/pkg/password"
+)
+
+func TestValidator(t *testing.T) {
+	require.NoError(t, InitValidator(password.DefaultPolicy()))
+
+	type signUpReq struct {
+		Email           string `json:"email" binding:"required,email"`
+		Password        string `json:"password" binding:"required,password"`
+		ConfirmPassword string `json:"confirmPassword" binding:"required,eqfield=Password"`
+	}
+	type editReq struct {
+		Nickname string `json:"nickname" binding:"required,max=50"`
+		Birthday string `json:"birthday" binding:"required,birthday"`
+	}
+
+	testCases := []struct {
+		name string
+		req  any
+		// 期望出错的字段，空代表校验通过
+		wantFields []string
+	}{
+		{
+			name: "注册成功",
+			req:  &signUpReq{Email: "a@qq.com", Password: "hello#123", ConfirmPassword: "hello#123"},
+		},
+		{
+			name:       "邮箱和密码都不对",
+			req:        &signUpReq{Email: "a.qq.com", Password: "hello", ConfirmPassword: "hello"},
+			wantFields: []string{"email", "password"},
+		},
+		{
+			name:       "两次密码不一致",
+			req:        &signUpReq{Email: "a@qq.com", Password: "hello#123", ConfirmPassword: "hello#124"},
+			wantFields: []string{"confirmPassword"},
+		},
+		{
+			name: "编辑成功",
+			req:  &editReq{Nickname: "花里", Birthday: "1995-07-28"},
+		},
+		{
+			name:       "昵称太长，生日在未来",
+			req:        &editReq{Nickname: string(make([]rune, 51)), Birthday: time.Now().AddDate(1, 0, 0).Format(time.DateOnly)},
+			wantFields: []string{"nickname", "birthday"},
+		},
+		{
+			name:       "生日太早",
+			req:        &editReq{Nickname: "花里", Birthday: "1899-12-31"},
+			wantFields: []string{"birthday"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := binding.Validator.ValidateStruct(tc.req)
+			if len(tc.wantFields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			fes := translateValidationErr(err)
+			fields := make([]string, 0, len(fes))
+			for _, fe := range fes {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tc.wantFields, fields)
+		})
+	}
+}
@@ -4,16 +4,27 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"net/http"
+	"os"
+	"strconv"
 
 	"strings"
 	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/gin-contrib/cors"
 	sredis "github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 
 	"gochuji/webook/internal/repository"
 	"gochuji/webook/internal/repository/cache"
@@ -21,9 +32,22 @@ import (
 	"gochuji/webook/internal/service"
 	"gochuji/webook/internal/web"
 	"gochuji/webook/internal/web/middleware"
+	"gochuji/webook/pkg/breaker"
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/metrics"
+	"gochuji/webook/pkg/otelx"
+	"gochuji/webook/pkg/outbox"
+	"gochuji/webook/pkg/password"
+	"gochuji/webook/pkg/ringBuffer"
+	"gochuji/webook/pkg/samarax"
+	"gochuji/webook/pkg/storage"
 )
 
 func main() {
+	initLogger()
+	initAdminServer()
+	shutdown := initTracer()
+	defer shutdown(context.Background())
 	db := initDB()
 	rdb, err := initRedis("81.71.139.129:6379", "qsgctys711!@#", 0)
 	if err != nil {
@@ -31,6 +55,7 @@ func main() {
 	}
 	server := initWebServer()
 	initUserHdl(db, rdb, server)
+	initUserEventsRelay(db)
 	err = server.Run(":8080")
 	if err != nil {
 		panic(err)
@@ -44,6 +69,15 @@ func initDB() *gorm.DB {
 		panic(err)
 	}
 
+	err = db.Use(tracing.NewPlugin(tracing.WithoutMetrics()))
+	if err != nil {
+		panic(err)
+	}
+	err = db.Use(metrics.NewGormPlugin(prometheus.DefaultRegisterer, "webook"))
+	if err != nil {
+		panic(err)
+	}
+
 	err = dao.InitTables(db)
 	if err != nil {
 		panic(err)
@@ -51,17 +85,148 @@ func initDB() *gorm.DB {
 	return db
 }
 
+var breakerHook = metrics.NewBreakerHook(prometheus.DefaultRegisterer, "webook")
+
+func newBreaker(name string) *breaker.Breaker {
+	cfg := breaker.DefaultConfig(name)
+	l := newLogger("breaker")
+	cfg.Hooks = append(cfg.Hooks, breakerHook, func(name string, from, to breaker.State) {
+		l.Warn("熔断器状态变化",
+			logger.String("name", name),
+			logger.String("from", from.String()),
+			logger.String("to", to.String()))
+	})
+	return breaker.New(ringBuffer.NewBitHealthBuffer(1024), cfg)
+}
+
+// logLevels 各个模块的日志级别，可以通过 initLogger 里面的管理接口调整
+var logLevels = logger.NewLevels(logger.InfoLevel)
+
+// initLogger 替换 zap 的全局 logger，logger.FromContext 找不到的时候用 default 模块的级别。
+// zap 开到 Debug，真正的级别由 logLevels 控制
+func initLogger() {
+	// 生产配置：JSON 编码，Error 才带堆栈
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	l, err := cfg.Build()
+	if err != nil {
+		panic(err)
+	}
+	zap.ReplaceGlobals(l)
+	logger.SetDefault(newLogger("default"))
+}
+
+// initAdminServer 内部的管理端口：调整日志级别、Prometheus 指标。
+// 都没有鉴权，只监听本机，不能挂在对外的 :8080 上
+// curl -X PUT localhost:8081/log/level -d '{"module":"outbox","level":"debug"}'
+func initAdminServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/log/level", logLevels)
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		err := http.ListenAndServe("127.0.0.1:8081", mux)
+		if err != nil {
+			newLogger("admin").Error("管理接口退出", logger.Error(err))
+		}
+	}()
+}
+
+func newLogger(module string) logger.LoggerV1 {
+	return logLevels.Wrap(logger.NewZapLogger(zap.L()), module)
+}
+
+// newSampledLogger Kafka、outbox 这种出错的时候每条消息都会打一遍的，限制一下重复的日志
+func newSampledLogger(module string) logger.LoggerV1 {
+	return logLevels.Wrap(logger.NewSampledLogger(logger.NewZapLogger(zap.L()), logger.DefaultSamplingConfig()), module)
+}
+
+// initTracer 链路数据发到本地的 OTLP collector
+func initTracer() func(ctx context.Context) error {
+	shutdown, err := otelx.InitOTLP(context.Background(), otelx.Config{
+		ServiceName: "webook",
+		Endpoint:    "localhost:4318",
+		Insecure:    true,
+		SampleRatio: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return shutdown
+}
+
 func initUserHdl(db *gorm.DB, rdb redis.Cmdable, server *gin.Engine) {
 	ud := dao.NewUserDAO(db)
 	uredis := cache.NewUserCache(rdb)
 	ur := repository.NewUserRepository(ud, uredis)
-	us := service.NewUserService(ur)
-	hdl := web.NewUserHandler(us)
+	policy := password.DefaultPolicy()
+	// 新密码用 argon2id，老的 bcrypt 哈希在用户下次登录的时候迁移过去
+	hasher := password.NewMultiHasher(
+		password.NewArgon2idHasher(password.DefaultArgon2idParams()),
+		password.NewBcryptHasher(bcryptCost()))
+	us := service.NewUserService(ur, policy, hasher)
+	// 开发环境先放本地磁盘，线上换成 storage.NewS3Storage
+	avatarStorage := storage.NewLocalStorage("./data/static", "http://localhost:8080/static")
+	server.Static("/static", "./data/static")
+	as := service.NewAvatarService(ur, avatarStorage, 2<<20)
+	ds := service.NewDeviceService(repository.NewDeviceRepository(cache.NewDeviceCache(rdb)))
+	hdl := web.NewUserHandler(us, as, ds, policy)
 	hdl.RegisterRoutes(server)
 }
 
+// bcryptCost 从环境变量 WEBOOK_BCRYPT_COST 读取，没有配置或者不合法的时候用 bcrypt.DefaultCost。
+// 现在新密码都用 argon2id，这个只影响还没迁移的老哈希
+func bcryptCost() int {
+	v := os.Getenv("WEBOOK_BCRYPT_COST")
+	if v == "" {
+		return bcrypt.DefaultCost
+	}
+	cost, err := strconv.Atoi(v)
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		newLogger("default").Warn("WEBOOK_BCRYPT_COST 不合法，使用默认值",
+			logger.String("value", v), logger.Int("default", bcrypt.DefaultCost))
+		return bcrypt.DefaultCost
+	}
+	return cost
+}
+
+// initUserEventsRelay 把 outbox 里面的用户事件转发到 Kafka
+func initUserEventsRelay(db *gorm.DB) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	// 配合 message-id 做幂等，broker 这边也不要重复写
+	cfg.Producer.Idempotent = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Net.MaxOpenRequests = 1
+	l := newSampledLogger("outbox")
+	senderCfg := samarax.DefaultSenderConfig()
+	metrics.RegisterHealthGauge(prometheus.DefaultRegisterer, "webook", "user_events_producer", senderCfg.Health)
+	// Kafka 挂了也要能启动，事件先留在 outbox 里面，连上之后再发
+	producer := samarax.NewLazySyncSender(func() (sarama.SyncProducer, error) {
+		p, err := sarama.NewSyncProducer([]string{"81.71.139.129:9094"}, cfg)
+		if err != nil {
+			l.Warn("连接 Kafka 失败，稍后重试", logger.Error(err))
+		}
+		return p, err
+	}, senderCfg)
+	sender := breaker.NewSender(newBreaker("kafka"), producer)
+	store := outbox.NewStore(db)
+	relay := outbox.NewRelay(store, sender, l)
+	go relay.Run(context.Background())
+	// 发出去的消息留一周方便排查
+	go store.RunCleanup(context.Background(), 7*24*time.Hour, time.Hour, l)
+}
+
 func initWebServer() *gin.Engine {
-	server := gin.Default()
+	// gin.Default 自带的日志直接打到标准输出，换成我们自己的访问日志
+	server := gin.New()
+	server.Use(gin.Recovery())
+	// 放在最前面，被登录校验拦下来的请求也要记下来。需要看请求体的时候加上 AllowReqBody
+	server.Use(middleware.NewAccessLogMiddlewareBuilder(newLogger("web")).Build())
+	// 让 ctx.Value 能拿到 otelgin 放在 Request.Context 里面的 span，
+	// 这样把 *gin.Context 传给 service 的时候，链路也能接上
+	server.ContextWithFallback = true
+	server.Use(otelgin.Middleware("webook"))
+	server.Use((&metrics.HTTPMiddlewareBuilder{Namespace: "webook"}).Build(prometheus.DefaultRegisterer))
 
 	server.Use(
 		cors.New(cors.Config{
@@ -69,11 +234,11 @@ func initWebServer() *gin.Engine {
 			//AllowOrigins:     []string{"http://localhost:3000"}, //允许跨域请求的域名
 			AllowCredentials: true, //允许跨域请求携带cookie
 
-			AllowHeaders: []string{"Content-Type", "Authorization"}, //允许跨域请求携带的header
+			AllowHeaders: []string{"Content-Type", "Authorization", web.DeviceIDHeader}, //允许跨域请求携带的header
 			//AllowHeaders: []string{"Content-Type"}, //允许跨域请求携带的header
 
 			//AllowMethods: []string{"POST"},				//允许跨域请求的方法
-			ExposeHeaders: []string{"x-jwt-token"},
+			ExposeHeaders: []string{"x-jwt-token", middleware.HeaderRequestID},
 
 			//自定义的校验规则
 			AllowOriginFunc: func(origin string) bool {
@@ -84,14 +249,7 @@ func initWebServer() *gin.Engine {
 				return strings.Contains(origin, "your_company.com")
 			},
 			MaxAge: 12 * time.Hour,
-		}),
-		func(ctx *gin.Context) {
-			println("这是我的 Middleware 01")
-		})
-
-	server.Use(func(ctx *gin.Context) {
-		println("这是我的 Middleware 02")
-	})
+		}))
 
 	//// 创建一个cookie存储，使用"secret"作为密钥
 	//store := cookie.NewStore([]byte("secret"))
@@ -104,6 +262,8 @@ func initWebServer() *gin.Engine {
 	//login := &middleware.LoginMiddlewareBuilder{}
 	//server.Use(login.CheckLogin())
 
+	// 放在登录校验前面，401 也要有 request id，uid 由登录校验加到 logger 上
+	server.Use(middleware.NewRequestLogMiddlewareBuilder(newLogger("web")).Build())
 	//jwt login 校验
 	login := middleware.LoginJWTMiddlewareBuilder{}
 	server.Use(login.CheckLogin())
@@ -118,8 +278,16 @@ func initRedis(addr, password string, db int) (*redis.Client, error) {
 		DB:       db,
 	})
 
+	err := redisotel.InstrumentTracing(client)
+	if err != nil {
+		return nil, err
+	}
+	client.AddHook(metrics.NewRedisHook(prometheus.DefaultRegisterer, "webook"))
+	// Redis 熔断的时候 UserCache 查询直接失败，回源到数据库
+	client.AddHook(breaker.NewRedisHook(newBreaker("redis")))
+
 	// 测试连接
-	_, err := client.Ping(context.Background()).Result()
+	_, err = client.Ping(context.Background()).Result()
 	if err != nil {
 		return nil, fmt.Errorf("无法连接到Redis: %v", err)
 	}
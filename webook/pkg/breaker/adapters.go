@@ -0,0 +1,123 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"gochuji/webook/pkg/samarax"
+)
+
+// Sender 给 Kafka 的 Sender 加上熔断，熔断的时候直接返回 ErrOpen
+type Sender struct {
+	b    *Breaker
+	next samarax.Sender
+}
+
+func NewSender(b *Breaker, next samarax.Sender) *Sender {
+	return &Sender{b: b, next: next}
+}
+
+func (s *Sender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	return s.b.Do(func() error {
+		return s.next.Send(ctx, msg)
+	})
+}
+
+// Healthy 熔断了就是不健康，让 outbox.FailoverSender 之类的直接走降级
+func (s *Sender) Healthy() bool {
+	if s.b.State() == StateOpen {
+		return false
+	}
+	if hs, ok := s.next.(interface{ Healthy() bool }); ok {
+		return hs.Healthy()
+	}
+	return true
+}
+
+// RedisHook 给 go-redis 加上熔断，用 AddHook 注册。
+// redis.Nil 只是 key 不存在，不算失败。UserCache 查询失败之后会回源到数据库
+type RedisHook struct {
+	b *Breaker
+}
+
+func NewRedisHook(b *Breaker) *RedisHook {
+	return &RedisHook{b: b}
+}
+
+func (h *RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		done, err := h.b.Allow()
+		if err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err = next(ctx, cmd)
+		done(redisErr(err))
+		return err
+	}
+}
+
+func (h *RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		done, err := h.b.Allow()
+		if err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err = next(ctx, cmds)
+		done(redisErr(err))
+		return err
+	}
+}
+
+func redisErr(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// ErrServerError 5xx 的响应算失败
+var ErrServerError = errors.New("breaker: 服务端错误")
+
+// RoundTripper 给外部的 HTTP 调用加上熔断，用法：
+//
+//	client := &http.Client{Transport: breaker.NewRoundTripper(b, http.DefaultTransport)}
+type RoundTripper struct {
+	b    *Breaker
+	next http.RoundTripper
+}
+
+func NewRoundTripper(b *Breaker, next http.RoundTripper) *RoundTripper {
+	return &RoundTripper{b: b, next: next}
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := rt.b.Allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		done(err)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		done(fmt.Errorf("%w: %d", ErrServerError, resp.StatusCode))
+	default:
+		done(nil)
+	}
+	return resp, err
+}
@@ -0,0 +1,196 @@
+// Package breaker 基于 ringBuffer 健康度的熔断器
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOpen = errors.New("breaker: 熔断中")
+	// ErrTooManyProbes 半开的时候试探的请求已经够多了
+	ErrTooManyProbes = errors.New("breaker: 试探请求太多")
+)
+
+type State int32
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Window 记录调用结果的健康度窗口，ringBuffer 里面的 BitHealthBuffer、
+// AtomicBitHealthBuffer 和 Bit1024HealthBuffer 都可以用
+type Window interface {
+	Push(success bool)
+	IsHealthy(minSuccessRate float64, minSamples int) bool
+	Reset()
+}
+
+// StateHook 状态变化的时候调用，用来打日志、记指标。在锁里面调用，不要做耗时的操作
+type StateHook func(name string, from, to State)
+
+type Config struct {
+	// 出现在日志和指标里面
+	Name           string
+	MinSuccessRate float64
+	MinSamples     int
+	// 熔断之后多久进入半开
+	CoolDown time.Duration
+	// 半开的时候最多同时放过去几个试探请求，这么多试探都成功了就恢复
+	MaxProbes int
+	// 返回 false 的错误不算失败，默认所有的错误都算
+	IsFailure func(err error) bool
+	// 返回 true 的结果不算成功也不算失败，直接跳过，默认跳过 context.Canceled。
+	// 调用方自己取消的，说明不了下游健不健康，算成功的话会把成功率抬高
+	Ignore func(err error) bool
+	Hooks  []StateHook
+}
+
+func DefaultConfig(name string) Config {
+	return Config{
+		Name:           name,
+		MinSuccessRate: 0.9,
+		MinSamples:     100,
+		CoolDown:       10 * time.Second,
+		MaxProbes:      3,
+	}
+}
+
+type Breaker struct {
+	cfg    Config
+	window Window
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	openedAt time.Time
+	// 每次状态变化都加一，上一个状态放过去的请求的结果就不算了
+	generation uint64
+	probes     int
+	successes  int
+}
+
+func New(window Window, cfg Config) *Breaker {
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(err error) bool {
+			return err != nil
+		}
+	}
+	if cfg.Ignore == nil {
+		cfg.Ignore = func(err error) bool {
+			return errors.Is(err, context.Canceled)
+		}
+	}
+	if cfg.MaxProbes <= 0 {
+		cfg.MaxProbes = 1
+	}
+	return &Breaker{cfg: cfg, window: window, now: time.Now}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkCoolDown()
+	return b.state
+}
+
+// Allow 判断能不能调用。可以的话，调用完要用结果调用 done，err 为 nil 代表成功
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkCoolDown()
+	switch b.state {
+	case StateOpen:
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.probes >= b.cfg.MaxProbes {
+			return nil, ErrTooManyProbes
+		}
+		b.probes++
+	}
+	gen := b.generation
+	return func(err error) {
+		b.report(gen, err)
+	}, nil
+}
+
+// Do 在熔断器的保护下调用 fn
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
+
+func (b *Breaker) report(gen uint64, err error) {
+	ignored := b.cfg.Ignore(err)
+	failed := !ignored && b.cfg.IsFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.generation {
+		return
+	}
+	if ignored {
+		if b.state == StateHalfOpen {
+			// 试探被取消了，让出名额给下一个请求
+			b.probes--
+		}
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		b.window.Push(!failed)
+		if !b.window.IsHealthy(b.cfg.MinSuccessRate, b.cfg.MinSamples) {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		if failed {
+			b.setState(StateOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.MaxProbes {
+			// 旧的失败不能再算进去，不然一恢复马上又熔断了
+			b.window.Reset()
+			b.setState(StateClosed)
+		}
+	}
+}
+
+func (b *Breaker) checkCoolDown() {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cfg.CoolDown {
+		b.setState(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(to State) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.probes, b.successes = 0, 0
+	if to == StateOpen {
+		b.openedAt = b.now()
+	}
+	for _, hook := range b.cfg.Hooks {
+		hook(b.cfg.Name, from, to)
+	}
+}
@@ -0,0 +1,195 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/pkg/ringBuffer"
+)
+
+func newTestBreaker(now *time.Time, hooks ...StateHook) *Breaker {
+	b := New(ringBuffer.NewBitHealthBuffer(10), Config{
+		Name:           "test",
+		MinSuccessRate: 0.5,
+		MinSamples:     4,
+		CoolDown:       time.Second,
+		MaxProbes:      2,
+		Hooks:          hooks,
+	})
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	var changes []string
+	b := newTestBreaker(&now, func(name string, from, to State) {
+		changes = append(changes, from.String()+"->"+to.String())
+	})
+	mockErr := errors.New("mock error")
+
+	// 样本不够的时候不熔断，取消的不算样本
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, mockErr, b.Do(func() error { return mockErr }))
+	}
+	assert.ErrorIs(t, b.Do(func() error { return context.Canceled }), context.Canceled)
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, mockErr, b.Do(func() error { return mockErr }))
+	assert.Equal(t, StateOpen, b.State())
+	assert.ErrorIs(t, b.Do(func() error { return nil }), ErrOpen)
+
+	// 冷却之后半开，最多放过去两个试探
+	now = now.Add(time.Second)
+	done1, err := b.Allow()
+	require.NoError(t, err)
+	done2, err := b.Allow()
+	require.NoError(t, err)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrTooManyProbes)
+	// 试探失败，重新熔断
+	done1(mockErr)
+	assert.Equal(t, StateOpen, b.State())
+	// 上一轮的结果不算
+	done2(nil)
+	assert.Equal(t, StateOpen, b.State())
+
+	// 两个试探都成功了，恢复
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Do(func() error { return nil }))
+	}
+	assert.Equal(t, StateClosed, b.State())
+	// 恢复之后旧的失败不再算
+	assert.Equal(t, mockErr, b.Do(func() error { return mockErr }))
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}, changes)
+}
+
+func TestBreaker_CanceledProbe(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+	for i := 0; i < 4; i++ {
+		_ = b.Do(func() error { return errors.New("mock error") })
+	}
+	require.Equal(t, StateOpen, b.State())
+
+	now = now.Add(time.Second)
+	done1, err := b.Allow()
+	require.NoError(t, err)
+	_, err = b.Allow()
+	require.NoError(t, err)
+	// 取消的试探让出名额，也不算成功
+	done1(context.Canceled)
+	assert.Equal(t, StateHalfOpen, b.State())
+	_, err = b.Allow()
+	assert.NoError(t, err)
+}
+
+func TestRedisHook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	b := newTestBreaker(&now)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	client.AddHook(NewRedisHook(b))
+	ctx := context.Background()
+
+	// key 不存在不算失败
+	for i := 0; i < 10; i++ {
+		assert.ErrorIs(t, client.Get(ctx, "missing").Err(), redis.Nil)
+	}
+	assert.Equal(t, StateClosed, b.State())
+
+	mr.SetError("mock error")
+	for i := 0; i < 10; i++ {
+		_ = client.Get(ctx, "key").Err()
+	}
+	assert.Equal(t, StateOpen, b.State())
+	// 熔断之后不发请求，直接返回 ErrOpen
+	mr.SetError("")
+	assert.ErrorIs(t, client.Get(ctx, "missing").Err(), ErrOpen)
+	_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Get(ctx, "missing")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrOpen)
+
+	// 冷却之后试探成功，恢复
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, client.Get(ctx, "missing").Err(), redis.Nil)
+	}
+	assert.Equal(t, StateClosed, b.State())
+}
+
+type fakeSender struct {
+	healthy bool
+	err     error
+}
+
+func (s *fakeSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	return s.err
+}
+
+func (s *fakeSender) Healthy() bool {
+	return s.healthy
+}
+
+func TestSender_Healthy(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+	next := &fakeSender{healthy: true, err: errors.New("mock error")}
+	s := NewSender(b, next)
+	assert.True(t, s.Healthy())
+	// 下游自己说不健康
+	next.healthy = false
+	assert.False(t, s.Healthy())
+
+	next.healthy = true
+	for i := 0; i < 4; i++ {
+		assert.Error(t, s.Send(context.Background(), &sarama.ProducerMessage{}))
+	}
+	// 熔断了就不健康，发送直接返回 ErrOpen
+	assert.False(t, s.Healthy())
+	assert.ErrorIs(t, s.Send(context.Background(), &sarama.ProducerMessage{}), ErrOpen)
+
+	// 下游没有实现 Healthy 的时候只看熔断器
+	assert.True(t, NewSender(newTestBreaker(&now), nopSender{}).Healthy())
+}
+
+type nopSender struct{}
+
+func (nopSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	return nil
+}
+
+func TestRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	now := time.Now()
+	b := newTestBreaker(&now)
+	client := &http.Client{Transport: NewRoundTripper(b, http.DefaultTransport)}
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, StateOpen, b.State())
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, ErrOpen)
+}
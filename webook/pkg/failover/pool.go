@@ -0,0 +1,180 @@
+// Package failover 多个外部服务商之间的故障转移，比如短信、邮件
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gochuji/webook/pkg/ringBuffer"
+)
+
+var (
+	// ErrNoAvailable 所有服务商都被摘掉了，也没到试探的时间
+	ErrNoAvailable = errors.New("failover: 没有可用的服务商")
+	// ErrAllFailed 能试的服务商都试过了，都失败了
+	ErrAllFailed = errors.New("failover: 所有服务商都失败了")
+)
+
+// Config 除了 ProbeInterval，没有设置的字段都用 DefaultConfig 里面的值
+type Config struct {
+	// 成功率低于 MinSuccessRate 就摘掉，样本少于 MinSamples 的时候不判断
+	MinSuccessRate float64
+	MinSamples     int
+	// 每次调用一个服务商的超时时间，超时了就换下一个
+	Timeout time.Duration
+	// 摘掉的服务商每隔多久试探一次，用真实的请求试探。0 代表每次请求都试探
+	ProbeInterval time.Duration
+	// 连续试探成功多少次之后重新启用
+	ReadmitAfter int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MinSuccessRate: 0.8,
+		MinSamples:     20,
+		Timeout:        3 * time.Second,
+		ProbeInterval:  30 * time.Second,
+		ReadmitAfter:   3,
+	}
+}
+
+type member[P any] struct {
+	name     string
+	provider P
+	health   *ringBuffer.Bit1024HealthBuffer
+
+	mu        sync.Mutex
+	ejected   bool
+	lastProbe time.Time
+	probeOK   int
+}
+
+// Pool 按照添加的顺序使用服务商，前面的失败了或者超时了就用下一个。
+// 成功率太低的服务商会被摘掉，之后定期拿真实请求试探，连续成功几次再重新启用
+type Pool[P any] struct {
+	members []*member[P]
+	cfg     Config
+	now     func() time.Time
+}
+
+func NewPool[P any](cfg Config) *Pool[P] {
+	// 比如 Timeout 是 0 的话，每次调用都会马上超时
+	def := DefaultConfig()
+	if cfg.MinSuccessRate <= 0 {
+		cfg.MinSuccessRate = def.MinSuccessRate
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = def.MinSamples
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.ReadmitAfter <= 0 {
+		cfg.ReadmitAfter = def.ReadmitAfter
+	}
+	return &Pool[P]{cfg: cfg, now: time.Now}
+}
+
+// Add 越早添加优先级越高
+func (p *Pool[P]) Add(name string, provider P) *Pool[P] {
+	p.members = append(p.members, &member[P]{
+		name:     name,
+		provider: provider,
+		health:   ringBuffer.NewBit1024HealthBuffer(),
+	})
+	return p
+}
+
+// Healthy 返回还在用的服务商的名字
+func (p *Pool[P]) Healthy() []string {
+	var res []string
+	for _, m := range p.members {
+		m.mu.Lock()
+		if !m.ejected {
+			res = append(res, m.name)
+		}
+		m.mu.Unlock()
+	}
+	return res
+}
+
+// Do 用第一个可用的服务商调用 fn，失败了换下一个
+func (p *Pool[P]) Do(ctx context.Context, fn func(ctx context.Context, provider P) error) error {
+	var errs []error
+	for _, m := range p.members {
+		if !p.available(m) {
+			continue
+		}
+		err := p.call(ctx, m, fn)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.name, err))
+		if ctx.Err() != nil {
+			// 调用方不等了，没必要再试下一个
+			return ctx.Err()
+		}
+	}
+	if len(errs) == 0 {
+		return ErrNoAvailable
+	}
+	return fmt.Errorf("%w: %w", ErrAllFailed, errors.Join(errs...))
+}
+
+// available 没被摘掉，或者到了试探的时间
+func (p *Pool[P]) available(m *member[P]) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.ejected {
+		return true
+	}
+	now := p.now()
+	if now.Sub(m.lastProbe) < p.cfg.ProbeInterval {
+		return false
+	}
+	m.lastProbe = now
+	return true
+}
+
+func (p *Pool[P]) call(ctx context.Context, m *member[P], fn func(ctx context.Context, provider P) error) error {
+	callCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	err := fn(callCtx, m.provider)
+	if err != nil && ctx.Err() != nil {
+		// 调用方自己取消的，不怪服务商
+		return err
+	}
+	p.report(m, err == nil)
+	return err
+}
+
+func (p *Pool[P]) report(m *member[P], success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ejected {
+		if !success {
+			m.probeOK = 0
+			return
+		}
+		m.probeOK++
+		if m.probeOK >= p.cfg.ReadmitAfter {
+			// 之前的失败不算了，重新开始统计
+			m.health.Reset()
+			m.ejected = false
+			m.probeOK = 0
+		} else {
+			// 试探成功了，下一次请求接着试探，不用再等
+			m.lastProbe = time.Time{}
+		}
+		return
+	}
+	m.health.Push(success)
+	if !m.health.IsHealthy(p.cfg.MinSuccessRate, p.cfg.MinSamples) {
+		m.ejected = true
+		m.lastProbe = p.now()
+		m.probeOK = 0
+	}
+}
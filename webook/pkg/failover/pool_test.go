@@ -0,0 +1,126 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider 返回 err，为 nil 就是成功
+type fakeProvider struct {
+	err   error
+	calls int
+}
+
+func call(ctx context.Context, p *fakeProvider) error {
+	p.calls++
+	return p.err
+}
+
+func TestNewPool_Defaults(t *testing.T) {
+	p := NewPool[*fakeProvider](Config{ProbeInterval: time.Minute})
+	def := DefaultConfig()
+	def.ProbeInterval = time.Minute
+	assert.Equal(t, def, p.cfg)
+
+	// Timeout 没有设置的时候不会马上超时
+	err := p.Add("a", &fakeProvider{}).Do(context.Background(), func(ctx context.Context, _ *fakeProvider) error {
+		return ctx.Err()
+	})
+	assert.NoError(t, err)
+}
+
+func TestPool_ProbeAndReadmit(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	primary := &fakeProvider{err: errors.New("mock error")}
+	backup := &fakeProvider{}
+	p := NewPool[*fakeProvider](Config{
+		MinSuccessRate: 0.5,
+		MinSamples:     2,
+		Timeout:        time.Second,
+		ProbeInterval:  time.Minute,
+		ReadmitAfter:   2,
+	}).Add("primary", primary).Add("backup", backup)
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// 失败两次之后摘掉
+	for i := 0; i < 2; i++ {
+		require.NoError(t, p.Do(ctx, call))
+	}
+	assert.Equal(t, []string{"backup"}, p.Healthy())
+
+	// 没到试探的时间，直接用 backup
+	now = now.Add(30 * time.Second)
+	require.NoError(t, p.Do(ctx, call))
+	assert.Equal(t, 2, primary.calls)
+
+	// 到了时间试探一次，失败了再等一个周期
+	now = now.Add(30 * time.Second)
+	require.NoError(t, p.Do(ctx, call))
+	assert.Equal(t, 3, primary.calls)
+	require.NoError(t, p.Do(ctx, call))
+	assert.Equal(t, 3, primary.calls)
+
+	// 恢复了，试探成功之后下一次接着试探，连续成功两次重新启用
+	primary.err = nil
+	now = now.Add(time.Minute)
+	require.NoError(t, p.Do(ctx, call))
+	assert.Equal(t, []string{"backup"}, p.Healthy())
+	require.NoError(t, p.Do(ctx, call))
+	assert.Equal(t, []string{"primary", "backup"}, p.Healthy())
+	assert.Equal(t, 5, primary.calls)
+	assert.Equal(t, 5, backup.calls)
+}
+
+func TestPool_NoAvailable(t *testing.T) {
+	only := &fakeProvider{err: errors.New("mock error")}
+	p := NewPool[*fakeProvider](Config{MinSamples: 1, ProbeInterval: time.Minute}).Add("only", only)
+	ctx := context.Background()
+
+	err := p.Do(ctx, call)
+	assert.ErrorIs(t, err, ErrAllFailed)
+	assert.ErrorContains(t, err, "only: mock error")
+	// 摘掉了，也没到试探的时间
+	assert.ErrorIs(t, p.Do(ctx, call), ErrNoAvailable)
+	assert.Equal(t, 1, only.calls)
+}
+
+func TestPool_Timeout(t *testing.T) {
+	slow, fast := &fakeProvider{}, &fakeProvider{}
+	p := NewPool[*fakeProvider](Config{MinSamples: 1, Timeout: 10 * time.Millisecond}).
+		Add("slow", slow).Add("fast", fast)
+	var used []*fakeProvider
+	err := p.Do(context.Background(), func(ctx context.Context, provider *fakeProvider) error {
+		used = append(used, provider)
+		if provider == slow {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*fakeProvider{slow, fast}, used)
+	// 超时算服务商的失败
+	assert.Equal(t, []string{"fast"}, p.Healthy())
+}
+
+func TestPool_CallerCancel(t *testing.T) {
+	a, b := &fakeProvider{}, &fakeProvider{}
+	p := NewPool[*fakeProvider](Config{MinSamples: 1}).Add("a", a).Add("b", b)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := p.Do(ctx, func(ctx context.Context, provider *fakeProvider) error {
+		provider.calls++
+		cancel()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	// 调用方不等了，不会再试下一个，也不怪服务商
+	assert.Equal(t, 1, a.calls)
+	assert.Equal(t, 0, b.calls)
+	assert.Equal(t, []string{"a", "b"}, p.Healthy())
+}
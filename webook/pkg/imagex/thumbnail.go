@@ -0,0 +1,24 @@
+package imagex
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// Thumbnail 从中间裁出最大的正方形，再缩放成 size x size。
+// 透明的部分铺成白色，方便统一编码成 JPEG
+func Thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		b.Min.X+(b.Dx()-side)/2,
+		b.Min.Y+(b.Dy()-side)/2,
+	))
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,34 @@
+package imagex
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThumbnail(t *testing.T) {
+	// 300x100 的图，中间 100x100 是红色，两边是蓝色
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 0; x < 300; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.RGBA{B: 255, A: 255}
+			if x >= 100 && x < 200 {
+				c = color.RGBA{R: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	dst := Thumbnail(src, 64)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), dst.Bounds())
+	// 裁剪之后只剩下中间的红色
+	r, g, b, _ := dst.At(32, 32).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0, 0}, []uint32{r, g, b})
+
+	// 透明的地方铺成白色
+	dst = Thumbnail(image.NewRGBA(image.Rect(0, 0, 10, 10)), 4)
+	r, g, b, _ = dst.At(1, 1).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0xffff, 0xffff}, []uint32{r, g, b})
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+type ctxKey struct{}
+
+type defaultHolder struct {
+	l LoggerV1
+}
+
+var defaultLogger atomic.Pointer[defaultHolder]
+
+// SetDefault 设置 FromContext 找不到 logger 的时候用的默认 logger，
+// 比如用 Levels.Wrap 包一层，让请求之外的日志也受级别控制
+func SetDefault(l LoggerV1) {
+	defaultLogger.Store(&defaultHolder{l: l})
+}
+
+// Default 没有 SetDefault 过的话用 zap 的全局 logger
+func Default() LoggerV1 {
+	if h := defaultLogger.Load(); h != nil {
+		return h.l
+	}
+	return NewZapLogger(zap.L())
+}
+
+// WithContext 把 l 放进 ctx，之后 FromContext 拿到的就是它
+func WithContext(ctx context.Context, l LoggerV1) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext 拿到 ctx 里面的 logger，比如带上了 request id 的。
+// 没有的话用 Default
+func FromContext(ctx context.Context) LoggerV1 {
+	if l, ok := ctx.Value(ctxKey{}).(LoggerV1); ok {
+		return l
+	}
+	return Default()
+}
+
+// FromContextOr 和 FromContext 一样，只是 ctx 里面没有的时候用 l
+func FromContextOr(ctx context.Context, l LoggerV1) LoggerV1 {
+	if cl, ok := ctx.Value(ctxKey{}).(LoggerV1); ok {
+		return cl
+	}
+	return l
+}
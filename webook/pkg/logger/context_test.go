@@ -0,0 +1,47 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	l := NewZapLogger(zap.New(core)).With(String("request_id", "abc"))
+
+	ctx := WithContext(context.Background(), l)
+	FromContext(ctx).With(Int64("uid", 123)).Info("hello", String("k", "v"))
+	// 子 logger 不影响父 logger
+	FromContext(ctx).Info("world")
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, map[string]any{"request_id": "abc", "uid": int64(123), "k": "v"}, entries[0].ContextMap())
+	assert.Equal(t, map[string]any{"request_id": "abc"}, entries[1].ContextMap())
+
+	// 没有放 logger 的时候也能用
+	assert.NotNil(t, FromContext(context.Background()))
+}
+
+func TestFromContext_Default(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	levels := NewLevels(WarnLevel)
+	SetDefault(levels.Wrap(NewZapLogger(zap.New(core)), "default"))
+	t.Cleanup(func() { defaultLogger.Store(nil) })
+
+	// 请求之外的日志也受 Levels 控制
+	FromContext(context.Background()).Info("info")
+	FromContext(context.Background()).Warn("warn")
+	levels.SetLevel("default", DebugLevel)
+	FromContext(context.Background()).Debug("debug")
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "warn", entries[0].Message)
+	assert.Equal(t, "debug", entries[1].Message)
+	assert.Equal(t, "default", entries[0].ContextMap()["module"])
+}
@@ -1,5 +1,7 @@
 package logger
 
+import "time"
+
 func Error(err error) Field {
 	return Field{Key: "error", Val: err}
 }
@@ -22,3 +24,11 @@ func Int64(key string, val int64) Field {
 func Int(key string, val int) Field {
 	return Field{Key: key, Val: val}
 }
+
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, Val: val}
+}
+
+func Any(key string, val any) Field {
+	return Field{Key: key, Val: val}
+}
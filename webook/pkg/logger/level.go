@@ -0,0 +1,206 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("logger: 未知的日志级别 %q", s)
+	}
+}
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	lvl, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = lvl
+	return nil
+}
+
+// Levels 运行时可以调整的日志级别，有一个全局的，每个模块还可以单独设置。
+// 底层的 logger 要开到 Debug，不然这里调低了也没用
+type Levels struct {
+	global atomic.Int32
+
+	mu      sync.RWMutex
+	modules map[string]Level
+}
+
+func NewLevels(global Level) *Levels {
+	ls := &Levels{modules: make(map[string]Level)}
+	ls.global.Store(int32(global))
+	return ls
+}
+
+// SetLevel module 为空的时候设置全局的级别
+func (ls *Levels) SetLevel(module string, lvl Level) {
+	if module == "" {
+		ls.global.Store(int32(lvl))
+		return
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.modules[module] = lvl
+}
+
+// ResetLevel 去掉模块单独的设置，回到全局的级别
+func (ls *Levels) ResetLevel(module string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	delete(ls.modules, module)
+}
+
+// Level 模块单独设置过就用模块的，否则用全局的
+func (ls *Levels) Level(module string) Level {
+	ls.mu.RLock()
+	lvl, ok := ls.modules[module]
+	ls.mu.RUnlock()
+	if ok {
+		return lvl
+	}
+	return Level(ls.global.Load())
+}
+
+func (ls *Levels) Enabled(module string, lvl Level) bool {
+	return lvl >= ls.Level(module)
+}
+
+// Wrap 返回按照 module 的级别过滤的 logger，日志里面带上 module 字段
+func (ls *Levels) Wrap(l LoggerV1, module string) LoggerV1 {
+	return &leveledLogger{l: l.With(String("module", module)), levels: ls, module: module}
+}
+
+// LevelsSnapshot 管理接口返回的当前级别
+type LevelsSnapshot struct {
+	Global  Level            `json:"global"`
+	Modules map[string]Level `json:"modules"`
+}
+
+func (ls *Levels) Snapshot() LevelsSnapshot {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	modules := make(map[string]Level, len(ls.modules))
+	for k, v := range ls.modules {
+		modules[k] = v
+	}
+	return LevelsSnapshot{Global: Level(ls.global.Load()), Modules: modules}
+}
+
+// SetLevelReq 管理接口调整级别的请求，Module 为空是全局，Level 为空是去掉模块单独的设置
+type SetLevelReq struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+}
+
+// ServeHTTP 管理接口：GET 查看当前级别，PUT 调整级别。
+// 没有鉴权，只能挂在内网的端口上
+func (ls *Levels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req SetLevelReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Level == "" {
+			if req.Module == "" {
+				http.Error(w, "全局的级别不能去掉", http.StatusBadRequest)
+				return
+			}
+			ls.ResetLevel(req.Module)
+			break
+		}
+		lvl, err := ParseLevel(req.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ls.SetLevel(req.Module, lvl)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ls.Snapshot())
+}
+
+// leveledLogger 每次打日志的时候查一下级别，所以调整之后马上生效
+type leveledLogger struct {
+	l      LoggerV1
+	levels *Levels
+	module string
+}
+
+func (ll *leveledLogger) Debug(msg string, args ...Field) {
+	if ll.levels.Enabled(ll.module, DebugLevel) {
+		ll.l.Debug(msg, args...)
+	}
+}
+
+func (ll *leveledLogger) Info(msg string, args ...Field) {
+	if ll.levels.Enabled(ll.module, InfoLevel) {
+		ll.l.Info(msg, args...)
+	}
+}
+
+func (ll *leveledLogger) Warn(msg string, args ...Field) {
+	if ll.levels.Enabled(ll.module, WarnLevel) {
+		ll.l.Warn(msg, args...)
+	}
+}
+
+func (ll *leveledLogger) Error(msg string, args ...Field) {
+	if ll.levels.Enabled(ll.module, ErrorLevel) {
+		ll.l.Error(msg, args...)
+	}
+}
+
+func (ll *leveledLogger) With(args ...Field) LoggerV1 {
+	return &leveledLogger{l: ll.l.With(args...), levels: ll.levels, module: ll.module}
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLevels(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	base := NewZapLogger(zap.New(core))
+	levels := NewLevels(InfoLevel)
+	kafka := levels.Wrap(base, "samarax")
+	web := levels.Wrap(base, "web").With(String("request_id", "abc"))
+
+	kafka.Debug("kafka debug")
+	web.Info("web info")
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, "web", logs.All()[0].ContextMap()["module"])
+
+	// 只调低 samarax 的
+	levels.SetLevel("samarax", DebugLevel)
+	kafka.Debug("kafka debug")
+	web.Debug("web debug")
+	assert.Equal(t, 1, logs.FilterMessage("kafka debug").Len())
+	assert.Equal(t, 0, logs.FilterMessage("web debug").Len())
+
+	// 全局调高之后，单独设置过的模块不受影响
+	levels.SetLevel("", ErrorLevel)
+	web.Warn("web warn")
+	kafka.Warn("kafka warn")
+	assert.Equal(t, 0, logs.FilterMessage("web warn").Len())
+	assert.Equal(t, 1, logs.FilterMessage("kafka warn").Len())
+
+	levels.ResetLevel("samarax")
+	assert.Equal(t, ErrorLevel, levels.Level("samarax"))
+}
+
+func TestLevels_ServeHTTP(t *testing.T) {
+	levels := NewLevels(InfoLevel)
+	testCases := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "查看",
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			wantBody: `{"global":"info","modules":{}}`,
+		},
+		{
+			name:     "调整模块",
+			method:   http.MethodPut,
+			body:     `{"module":"samarax","level":"debug"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"global":"info","modules":{"samarax":"debug"}}`,
+		},
+		{
+			name:     "调整全局",
+			method:   http.MethodPut,
+			body:     `{"level":"WARN"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"global":"warn","modules":{"samarax":"debug"}}`,
+		},
+		{
+			name:     "去掉模块的设置",
+			method:   http.MethodPut,
+			body:     `{"module":"samarax"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"global":"warn","modules":{}}`,
+		},
+		{
+			name:     "不认识的级别",
+			method:   http.MethodPut,
+			body:     `{"level":"verbose"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "不能去掉全局",
+			method:   http.MethodPut,
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "不支持的方法",
+			method:   http.MethodDelete,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/log/level", strings.NewReader(tc.body))
+			recorder := httptest.NewRecorder()
+			levels.ServeHTTP(recorder, req)
+			require.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantBody != "" {
+				assert.JSONEq(t, tc.wantBody, recorder.Body.String())
+			}
+		})
+	}
+}
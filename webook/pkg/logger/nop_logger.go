@@ -0,0 +1,20 @@
+package logger
+
+// NopLogger 什么都不打，测试的时候用
+type NopLogger struct{}
+
+func NewNopLogger() NopLogger {
+	return NopLogger{}
+}
+
+func (NopLogger) Debug(msg string, args ...Field) {}
+
+func (NopLogger) Info(msg string, args ...Field) {}
+
+func (NopLogger) Warn(msg string, args ...Field) {}
+
+func (NopLogger) Error(msg string, args ...Field) {}
+
+func (n NopLogger) With(args ...Field) LoggerV1 {
+	return n
+}
@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// SamplingConfig 每个 Tick 里面，同一个级别的同一条消息先打 First 条，
+// 之后每 Thereafter 条打一条。Thereafter 为 0 就是之后的都不打
+type SamplingConfig struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+func DefaultSamplingConfig() SamplingConfig {
+	return SamplingConfig{
+		Tick:       time.Second,
+		First:      10,
+		Thereafter: 100,
+	}
+}
+
+// NewSampledLogger 限制重复的日志，比如 Kafka 里面一堆坏消息的时候，
+// "反序列消息体失败" 不会把日志刷屏。被丢掉的条数会带在下一条打出来的日志里面
+func NewSampledLogger(l LoggerV1, cfg SamplingConfig) LoggerV1 {
+	return &sampledLogger{l: l, s: &sampler{cfg: cfg, counters: make(map[sampleKey]*sampleCounter), now: time.Now}}
+}
+
+type sampledLogger struct {
+	l LoggerV1
+	// With 出来的子 logger 共用一个 sampler
+	s *sampler
+}
+
+func (sl *sampledLogger) Debug(msg string, args ...Field) {
+	if ok, args := sl.s.check(DebugLevel, msg, args); ok {
+		sl.l.Debug(msg, args...)
+	}
+}
+
+func (sl *sampledLogger) Info(msg string, args ...Field) {
+	if ok, args := sl.s.check(InfoLevel, msg, args); ok {
+		sl.l.Info(msg, args...)
+	}
+}
+
+func (sl *sampledLogger) Warn(msg string, args ...Field) {
+	if ok, args := sl.s.check(WarnLevel, msg, args); ok {
+		sl.l.Warn(msg, args...)
+	}
+}
+
+func (sl *sampledLogger) Error(msg string, args ...Field) {
+	if ok, args := sl.s.check(ErrorLevel, msg, args); ok {
+		sl.l.Error(msg, args...)
+	}
+}
+
+func (sl *sampledLogger) With(args ...Field) LoggerV1 {
+	return &sampledLogger{l: sl.l.With(args...), s: sl.s}
+}
+
+type sampleKey struct {
+	level Level
+	msg   string
+}
+
+type sampleCounter struct {
+	start   time.Time
+	n       int
+	dropped int
+}
+
+type sampler struct {
+	cfg SamplingConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	counters map[sampleKey]*sampleCounter
+}
+
+// check 返回这一条要不要打，要打的话前面丢掉过日志就在 args 里面加上丢掉的条数
+func (s *sampler) check(level Level, msg string, args []Field) (bool, []Field) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sampleKey{level: level, msg: msg}
+	c, ok := s.counters[key]
+	if !ok || now.Sub(c.start) >= s.cfg.Tick {
+		dropped := 0
+		if ok {
+			dropped = c.dropped
+		}
+		c = &sampleCounter{start: now, dropped: dropped}
+		s.counters[key] = c
+	}
+	c.n++
+	if c.n > s.cfg.First && (s.cfg.Thereafter <= 0 || (c.n-s.cfg.First)%s.cfg.Thereafter != 0) {
+		c.dropped++
+		return false, args
+	}
+	if c.dropped > 0 {
+		args = append(args[:len(args):len(args)], Int("sampled_dropped", c.dropped))
+		c.dropped = 0
+	}
+	return true, args
+}
@@ -0,0 +1,37 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSampledLogger(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	now := time.Now()
+	l := NewSampledLogger(NewZapLogger(zap.New(core)), SamplingConfig{Tick: time.Second, First: 2, Thereafter: 3})
+	l.(*sampledLogger).s.now = func() time.Time { return now }
+	// With 出来的子 logger 和父 logger 一起计数
+	child := l.With(String("topic", "t"))
+
+	for i := 0; i < 5; i++ {
+		child.Error("反序列消息体失败")
+	}
+	l.Warn("反序列消息体失败")
+	l.Error("处理消息失败")
+	// 前 2 条，之后第 3 条打一条，级别不同、消息不同的分开计数
+	assert.Equal(t, 3, logs.FilterMessage("反序列消息体失败").FilterField(zap.String("topic", "t")).Len())
+	assert.Equal(t, 1, logs.FilterMessage("处理消息失败").Len())
+	assert.Equal(t, int64(2), logs.All()[2].ContextMap()["sampled_dropped"])
+
+	// 下一个 tick 重新计数，带上上一个 tick 最后丢掉的条数
+	child.Error("反序列消息体失败")
+	now = now.Add(time.Second)
+	child.Error("反序列消息体失败")
+	entries := logs.FilterMessage("反序列消息体失败").FilterField(zap.String("topic", "t")).All()
+	assert.Len(t, entries, 4)
+	assert.Equal(t, int64(1), entries[3].ContextMap()["sampled_dropped"])
+}
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+type SlogLogger struct {
+	l *slog.Logger
+}
+
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	return &SlogLogger{
+		l: l,
+	}
+}
+
+func (s *SlogLogger) Debug(msg string, args ...Field) {
+	s.log(slog.LevelDebug, msg, args)
+}
+
+func (s *SlogLogger) Info(msg string, args ...Field) {
+	s.log(slog.LevelInfo, msg, args)
+}
+
+func (s *SlogLogger) Warn(msg string, args ...Field) {
+	s.log(slog.LevelWarn, msg, args)
+}
+
+func (s *SlogLogger) Error(msg string, args ...Field) {
+	s.log(slog.LevelError, msg, args)
+}
+
+func (s *SlogLogger) With(args ...Field) LoggerV1 {
+	return &SlogLogger{l: slog.New(s.l.Handler().WithAttrs(s.toAttrs(args)))}
+}
+
+func (s *SlogLogger) log(level slog.Level, msg string, args []Field) {
+	s.l.LogAttrs(context.Background(), level, msg, s.toAttrs(args)...)
+}
+
+func (s *SlogLogger) toAttrs(args []Field) []slog.Attr {
+	res := make([]slog.Attr, 0, len(args))
+	for _, arg := range args {
+		res = append(res, slog.Any(arg.Key, arg.Val))
+	}
+	return res
+}
@@ -0,0 +1,30 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	l.With(String("request_id", "abc")).Error("error message", Int64("uid", 123), Error(errors.New("boom")))
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "error message", entry["msg"])
+	assert.Equal(t, "abc", entry["request_id"])
+	assert.Equal(t, float64(123), entry["uid"])
+	assert.Equal(t, "boom", entry["error"])
+
+	// NopLogger 什么都不打，也不会 panic
+	var nop LoggerV1 = NewNopLogger()
+	nop.With(String("k", "v")).Error("ignored")
+}
@@ -5,6 +5,8 @@ type LoggerV1 interface {
 	Info(msg string, args ...Field)
 	Warn(msg string, args ...Field)
 	Error(msg string, args ...Field)
+	// With 返回带上 args 的子 logger，之后每一条日志都有这些字段
+	With(args ...Field) LoggerV1
 }
 
 type Field struct {
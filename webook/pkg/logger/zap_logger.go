@@ -28,6 +28,10 @@ func (z *ZapLogger) Error(msg string, args ...Field) {
 	z.zl.Error(msg, z.toArgs(args)...)
 }
 
+func (z *ZapLogger) With(args ...Field) LoggerV1 {
+	return &ZapLogger{zl: z.zl.With(z.toArgs(args)...)}
+}
+
 func (z *ZapLogger) toArgs(args []Field) []zap.Field {
 	res := make([]zap.Field, 0, len(args))
 	for _, arg := range args {
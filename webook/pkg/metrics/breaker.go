@@ -0,0 +1,19 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gochuji/webook/pkg/breaker"
+)
+
+// NewBreakerHook 熔断器的状态，0 关闭，1 打开，2 半开
+func NewBreakerHook(reg prometheus.Registerer, namespace string) breaker.StateHook {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_state",
+		Help:      "熔断器的状态，0 关闭，1 打开，2 半开",
+	}, []string{"name"})
+	reg.MustRegister(gauge)
+	return func(name string, from, to breaker.State) {
+		gauge.WithLabelValues(name).Set(float64(to))
+	}
+}
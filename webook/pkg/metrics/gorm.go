@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const gormStartKey = "metrics:start"
+
+// GormPlugin 用 GORM 的回调统计每种语句的执行时间，用 db.Use 注册
+type GormPlugin struct {
+	hist *prometheus.HistogramVec
+}
+
+func NewGormPlugin(reg prometheus.Registerer, namespace string) *GormPlugin {
+	hist := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gorm_query_duration_seconds",
+		Help:      "GORM 执行语句的时间",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"type", "table"})
+	reg.MustRegister(hist)
+	return &GormPlugin{hist: hist}
+}
+
+func (p *GormPlugin) Name() string {
+	return "metrics"
+}
+
+func (p *GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("metrics:before_create", p.before),
+		cb.Create().After("*").Register("metrics:after_create", p.after("create")),
+		cb.Query().Before("*").Register("metrics:before_query", p.before),
+		cb.Query().After("*").Register("metrics:after_query", p.after("query")),
+		cb.Update().Before("*").Register("metrics:before_update", p.before),
+		cb.Update().After("*").Register("metrics:after_update", p.after("update")),
+		cb.Delete().Before("*").Register("metrics:before_delete", p.before),
+		cb.Delete().After("*").Register("metrics:after_delete", p.after("delete")),
+		cb.Row().Before("*").Register("metrics:before_row", p.before),
+		cb.Row().After("*").Register("metrics:after_row", p.after("row")),
+		cb.Raw().Before("*").Register("metrics:before_raw", p.before),
+		cb.Raw().After("*").Register("metrics:after_raw", p.after("raw")),
+	)
+}
+
+func (p *GormPlugin) before(db *gorm.DB) {
+	db.InstanceSet(gormStartKey, time.Now())
+}
+
+func (p *GormPlugin) after(typ string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		val, ok := db.InstanceGet(gormStartKey)
+		if !ok {
+			return
+		}
+		start, ok := val.(time.Time)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		p.hist.WithLabelValues(typ, table).Observe(time.Since(start).Seconds())
+	}
+}
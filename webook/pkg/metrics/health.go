@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gochuji/webook/pkg/ringBuffer"
+)
+
+// RegisterHealthGauge 把健康度的成功率暴露出去，抓取的时候才计算
+func RegisterHealthGauge(reg prometheus.Registerer, namespace, name string, buf *ringBuffer.Bit1024HealthBuffer) {
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "health_success_rate",
+		Help:        "最近 1024 次调用的成功率",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, buf.SuccessRate))
+}
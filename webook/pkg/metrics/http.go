@@ -0,0 +1,38 @@
+// Package metrics 各层的 Prometheus 指标
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTPMiddlewareBuilder 统计每个路由的响应时间
+type HTTPMiddlewareBuilder struct {
+	Namespace string
+	Subsystem string
+}
+
+func (b *HTTPMiddlewareBuilder) Build(reg prometheus.Registerer) gin.HandlerFunc {
+	hist := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: b.Namespace,
+		Subsystem: b.Subsystem,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP 请求的响应时间",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	reg.MustRegister(hist)
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		// 用路由模板，不然 /users/:id/profile 这种每个 id 都是一个标签
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unknown"
+		}
+		hist.WithLabelValues(route, ctx.Request.Method, strconv.Itoa(ctx.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
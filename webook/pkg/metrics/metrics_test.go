@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"gochuji/webook/pkg/ringBuffer"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	server := gin.New()
+	server.Use((&HTTPMiddlewareBuilder{Namespace: "test"}).Build(reg))
+	server.GET("/users/:id/profile", func(ctx *gin.Context) {
+		ctx.Status(http.StatusNotFound)
+	})
+	for _, path := range []string{"/users/1/profile", "/users/2/profile"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	// 两个 id 算在同一个路由下面
+	assert.Equal(t, 1, testutil.CollectAndCount(reg, "test_http_request_duration_seconds"))
+}
+
+func TestKeyPrefix(t *testing.T) {
+	testCases := []struct {
+		cmd  redis.Cmder
+		want string
+	}{
+		{cmd: redis.NewStringCmd(nil, "get", "user:info:123"), want: "user:info"},
+		{cmd: redis.NewStringCmd(nil, "get", "plain"), want: "plain"},
+		{cmd: redis.NewStatusCmd(nil, "ping"), want: ""},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, keyPrefix(tc.cmd))
+	}
+}
+
+func TestHealthGauge(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	buf := ringBuffer.NewBit1024HealthBuffer()
+	RegisterHealthGauge(reg, "test", "kafka", buf)
+	buf.Push(true)
+	buf.Push(false)
+	mfs, err := reg.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, mfs, 1)
+	assert.InDelta(t, 0.5, mfs[0].GetMetric()[0].GetGauge().GetValue(), 1e-9)
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHook 统计命令的耗时，以及 GET 的命中情况。
+// 命中率按照 key 的前缀分组，比如 user:info:123 算在 user:info 下面，用 AddHook 注册
+type RedisHook struct {
+	latency *prometheus.HistogramVec
+	hits    *prometheus.CounterVec
+}
+
+func NewRedisHook(reg prometheus.Registerer, namespace string) *RedisHook {
+	h := &RedisHook{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "redis_command_duration_seconds",
+			Help:      "Redis 命令的耗时",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		}, []string{"cmd", "key_prefix"}),
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redis_get_total",
+			Help:      "GET 的次数，hit 是 true 代表命中了",
+		}, []string{"key_prefix", "hit"}),
+	}
+	reg.MustRegister(h.latency, h.hits)
+	return h
+}
+
+func (h *RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		prefix := keyPrefix(cmd)
+		h.latency.WithLabelValues(cmd.Name(), prefix).Observe(time.Since(start).Seconds())
+		if cmd.Name() == "get" {
+			switch {
+			case err == nil:
+				h.hits.WithLabelValues(prefix, "true").Inc()
+			case errors.Is(err, redis.Nil):
+				h.hits.WithLabelValues(prefix, "false").Inc()
+			}
+		}
+		return err
+	}
+}
+
+func (h *RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.latency.WithLabelValues("pipeline", "").Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// keyPrefix 去掉 key 最后一段，一般是 id
+func keyPrefix(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return ""
+	}
+	key, ok := args[1].(string)
+	if !ok {
+		return ""
+	}
+	if idx := strings.LastIndexByte(key, ':'); idx >= 0 {
+		return key[:idx]
+	}
+	return key
+}
@@ -0,0 +1,60 @@
+// Package otelx 初始化 OpenTelemetry 的链路追踪
+package otelx
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type Config struct {
+	ServiceName string
+	// OTLP HTTP 的地址，比如 localhost:4318
+	Endpoint string
+	Insecure bool
+	// 采样比例，0 到 1，根 span 按照这个比例采样，子 span 跟着父 span 走
+	SampleRatio float64
+}
+
+// InitOTLP 设置全局的 TracerProvider 和 W3C trace context 的 propagator，
+// 返回的函数在退出之前调用，把还没发出去的 span 发出去
+func InitOTLP(ctx context.Context, cfg Config) (func(ctx context.Context) error, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(newResource(cfg.ServiceName)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	setGlobal(tp)
+	return tp.Shutdown, nil
+}
+
+// NewInMemory 测试用，span 结束之后马上写进 exporter，用 exporter.GetSpans() 检查
+func NewInMemory() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return tp, exporter
+}
+
+func newResource(serviceName string) *resource.Resource {
+	return resource.NewSchemaless(attribute.String("service.name", serviceName))
+}
+
+func setGlobal(tp *sdktrace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax"
+)
+
+// HealthySender 能告诉我们 Kafka 健不健康的 Sender，比如 samarax.SyncSender
+type HealthySender interface {
+	samarax.Sender
+	Healthy() bool
+}
+
+// FailoverStore FailoverSender 需要的 outbox 操作，*Store 实现了它
+type FailoverStore interface {
+	Repository
+	Insert(ctx context.Context, msgs ...Message) error
+}
+
+// FailoverSender Kafka 不健康的时候把消息写进 outbox，Run 在后台把它们补发到 Kafka。
+// outbox 里面还有消息的时候，新消息也写进 outbox 排在后面，这样同一个 key 的顺序不会乱
+type FailoverSender struct {
+	kafka HealthySender
+	store FailoverStore
+	relay *Relay
+	l     logger.LoggerV1
+
+	mu sync.Mutex
+	// outbox 里面可能还有消息
+	draining bool
+	// 每写成功一次 outbox 加一，用来判断 Drain 的时候有没有新写进来的消息
+	gen uint64
+	// 正在写 outbox 的条数，还没提交的消息 Drain 查不到
+	inflight int
+}
+
+func NewFailoverSender(kafka HealthySender, store FailoverStore, l logger.LoggerV1) *FailoverSender {
+	return &FailoverSender{
+		kafka: kafka,
+		store: store,
+		// 补发也走同一个 Sender，试探成功了健康度就会慢慢恢复
+		relay: NewRelay(store, kafka, l),
+		l:     l,
+		// 重启之前可能还有没补发完的消息
+		draining: true,
+	}
+}
+
+func (f *FailoverSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	f.mu.Lock()
+	draining := f.draining
+	f.mu.Unlock()
+	if !draining && f.kafka.Healthy() {
+		err := f.kafka.Send(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		f.l.Warn("发送到 Kafka 失败，转存 outbox",
+			logger.String("topic", msg.Topic), logger.Error(err))
+	}
+	return f.save(ctx, msg)
+}
+
+func (f *FailoverSender) save(ctx context.Context, msg *sarama.ProducerMessage) error {
+	m, err := NewMessage(msg)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.draining = true
+	f.inflight++
+	f.mu.Unlock()
+	err = f.store.Insert(ctx, m)
+	f.mu.Lock()
+	f.inflight--
+	if err == nil {
+		f.gen++
+	}
+	f.mu.Unlock()
+	return err
+}
+
+// Healthy Kafka 健康并且 outbox 已经清空了
+func (f *FailoverSender) Healthy() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.draining && f.kafka.Healthy()
+}
+
+// Run 每隔 interval 尝试补发 outbox 里面的消息，直到 ctx 结束
+func (f *FailoverSender) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *FailoverSender) drain(ctx context.Context) {
+	f.mu.Lock()
+	draining, gen, inflight := f.draining, f.gen, f.inflight
+	f.mu.Unlock()
+	if !draining {
+		return
+	}
+	empty, err := f.relay.Drain(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			f.l.Error("补发 outbox 消息失败", logger.Error(err))
+		}
+		return
+	}
+	if !empty {
+		return
+	}
+	f.mu.Lock()
+	// 开始的时候有还没提交的，或者查询的时候又有消息写进来了，可能没查到，下一轮再看
+	if inflight == 0 && f.inflight == 0 && f.gen == gen {
+		f.draining = false
+	}
+	f.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"gochuji/webook/pkg/logger"
+)
+
+// fakeKafka 可以切换健康状态，down 的时候发送失败
+type fakeKafka struct {
+	mu   sync.Mutex
+	down bool
+	sent []string
+}
+
+func (k *fakeKafka) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.down {
+		return errors.New("kafka 挂了")
+	}
+	val, _ := msg.Value.Encode()
+	k.sent = append(k.sent, string(val))
+	return nil
+}
+
+func (k *fakeKafka) Healthy() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return !k.down
+}
+
+func (k *fakeKafka) setDown(down bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.down = down
+}
+
+func (k *fakeKafka) sentValues() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string(nil), k.sent...)
+}
+
+func newProducerMsg(key, val string) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{Topic: "t", Key: sarama.StringEncoder(key), Value: sarama.StringEncoder(val)}
+}
+
+func newTestFailoverSender(t *testing.T) (*FailoverSender, *fakeKafka, *memRepo) {
+	kafka, repo := &fakeKafka{}, &memRepo{}
+	f := NewFailoverSender(kafka, repo, logger.NewZapLogger(zap.NewNop()))
+	// 启动的时候 outbox 是空的
+	f.drain(context.Background())
+	require.True(t, f.Healthy())
+	return f, kafka, repo
+}
+
+func TestFailoverSender_SwitchAndRecover(t *testing.T) {
+	f, kafka, repo := newTestFailoverSender(t)
+	ctx := context.Background()
+
+	require.NoError(t, f.Send(ctx, newProducerMsg("a", "a1")))
+	assert.Equal(t, []string{"a1"}, kafka.sentValues())
+
+	// Kafka 挂了，写进 outbox
+	kafka.setDown(true)
+	require.NoError(t, f.Send(ctx, newProducerMsg("a", "a2")))
+	assert.False(t, f.Healthy())
+	f.drain(ctx)
+	assert.False(t, f.Healthy())
+
+	// Kafka 恢复了，但是 outbox 还没清空，新消息也要排在后面
+	kafka.setDown(false)
+	require.NoError(t, f.Send(ctx, newProducerMsg("a", "a3")))
+	assert.Equal(t, []string{"a1"}, kafka.sentValues())
+	assert.Len(t, repo.msgs, 2)
+
+	f.drain(ctx)
+	assert.True(t, f.Healthy())
+	assert.Equal(t, []string{"a1", "a2", "a3"}, kafka.sentValues())
+
+	// 清空之后直接发 Kafka
+	require.NoError(t, f.Send(ctx, newProducerMsg("a", "a4")))
+	assert.Equal(t, []string{"a1", "a2", "a3", "a4"}, kafka.sentValues())
+	assert.Len(t, repo.msgs, 2)
+}
+
+func TestFailoverSender_InsertRacingDrain(t *testing.T) {
+	f, kafka, repo := newTestFailoverSender(t)
+	ctx := context.Background()
+
+	kafka.setDown(true)
+	inserting, commit := make(chan struct{}), make(chan struct{})
+	repo.beforeInsert = func() {
+		close(inserting)
+		<-commit
+	}
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- f.Send(ctx, newProducerMsg("a", "a1"))
+	}()
+	<-inserting
+
+	// 事务还没提交，Drain 查不到这条消息，不能认为 outbox 已经空了
+	kafka.setDown(false)
+	f.drain(ctx)
+	assert.False(t, f.Healthy())
+
+	close(commit)
+	require.NoError(t, <-sendErr)
+	repo.beforeInsert = nil
+
+	// 同一个 key 的下一条不能越过 outbox 里面的那条
+	require.NoError(t, f.Send(ctx, newProducerMsg("a", "a2")))
+	assert.Empty(t, kafka.sentValues())
+	f.drain(ctx)
+	assert.Equal(t, []string{"a1", "a2"}, kafka.sentValues())
+	assert.True(t, f.Healthy())
+}
@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax"
+)
+
+// Repository Relay 需要的 outbox 操作，*Store 实现了它
+type Repository interface {
+	Pending(ctx context.Context, limit int) ([]Message, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64) error
+	IncrAttempts(ctx context.Context, id int64) error
+}
+
+// Relay 把 outbox 里面的消息按照写入的顺序发到 Kafka。
+// 同一个 key 的消息，前面的没发出去，后面的这一轮就不发，保证每个 key 的顺序
+type Relay struct {
+	repo      Repository
+	sender    samarax.Sender
+	l         logger.LoggerV1
+	batchSize int
+	interval  time.Duration
+	// 一条消息最多发几次，用完了就标记成失败，不能一直卡住后面的消息
+	maxAttempts int
+}
+
+func NewRelay(repo Repository, sender samarax.Sender, l logger.LoggerV1) *Relay {
+	return &Relay{
+		repo:        repo,
+		sender:      sender,
+		l:           l,
+		batchSize:   100,
+		interval:    time.Second,
+		maxAttempts: 10,
+	}
+}
+
+// Run 每隔 interval 清空一次 outbox，直到 ctx 结束
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		_, err := r.Drain(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.l.Error("转发 outbox 消息失败", logger.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain 一批一批地发送 outbox 里面的消息。
+// 返回 true 代表 outbox 已经空了；碰到发送失败的就停下来，等下一轮
+func (r *Relay) Drain(ctx context.Context) (bool, error) {
+	for {
+		msgs, err := r.repo.Pending(ctx, r.batchSize)
+		if err != nil {
+			return false, err
+		}
+		if len(msgs) == 0 {
+			return true, nil
+		}
+		sent, err := r.sendBatch(ctx, msgs)
+		if err != nil {
+			return false, err
+		}
+		if sent < len(msgs) {
+			// 有消息没发出去，Kafka 大概率还没恢复
+			return false, nil
+		}
+	}
+}
+
+// sendBatch 返回处理掉的条数，包括发送成功的和丢弃的。
+// 还没有发成功过就失败了，说明 Kafka 大概率还没恢复，这一轮后面的都不发了。
+// 重试次数用完的消息可能是它自己有问题（比如太大了），再拿下一条试一下，
+// 后面有发成功的才把它标记成失败，Kafka 挂了的时候不会误伤
+func (r *Relay) sendBatch(ctx context.Context, msgs []Message) (int, error) {
+	// 这一轮里面发送失败过的 key
+	blocked := make(map[string]bool)
+	// 这一轮里面失败了并且重试次数用完了的
+	var exhausted []Message
+	handled, delivered := 0, false
+	for _, m := range msgs {
+		if m.Key != nil && blocked[string(m.Key)] {
+			continue
+		}
+		pm, err := m.ProducerMessage()
+		if err != nil {
+			// 存进去的数据坏了，重试也没用，跳过它，不能卡住后面的消息
+			r.l.Error("outbox 消息格式不对，丢弃",
+				logger.Int64("id", m.Id), logger.Error(err))
+			if err = r.repo.MarkSent(ctx, m.Id); err != nil {
+				return handled, err
+			}
+			handled++
+			continue
+		}
+		err = r.sender.Send(ctx, pm)
+		if err != nil {
+			r.l.Warn("outbox 消息发送失败",
+				logger.Int64("id", m.Id),
+				logger.String("topic", m.Topic),
+				logger.Int("attempts", m.Attempts+1),
+				logger.Error(err))
+			if err = r.repo.IncrAttempts(ctx, m.Id); err != nil {
+				return handled, err
+			}
+			if m.Attempts+1 >= r.maxAttempts {
+				exhausted = append(exhausted, m)
+			}
+			if !delivered && (len(exhausted) == 0 || exhausted[0].Id != m.Id) {
+				return handled, nil
+			}
+			if m.Key != nil {
+				blocked[string(m.Key)] = true
+			}
+			continue
+		}
+		if err = r.repo.MarkSent(ctx, m.Id); err != nil {
+			return handled, err
+		}
+		handled++
+		delivered = true
+	}
+	if !delivered {
+		return handled, nil
+	}
+	for _, m := range exhausted {
+		r.l.Error("outbox 消息重试次数用完了，标记成失败",
+			logger.Int64("id", m.Id),
+			logger.String("topic", m.Topic),
+			logger.Int("attempts", m.Attempts+1))
+		if err := r.repo.MarkFailed(ctx, m.Id); err != nil {
+			return handled, err
+		}
+		handled++
+	}
+	return handled, nil
+}
@@ -0,0 +1,242 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"gochuji/webook/pkg/logger"
+)
+
+type memRepo struct {
+	mu   sync.Mutex
+	msgs []Message
+	// beforeInsert 不为 nil 的时候，Insert 提交之前先调用它，用来模拟还没提交的事务
+	beforeInsert func()
+}
+
+func (r *memRepo) Insert(ctx context.Context, msgs ...Message) error {
+	if r.beforeInsert != nil {
+		r.beforeInsert()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		m.Id = int64(len(r.msgs) + 1)
+		m.Status = StatusPending
+		r.msgs = append(r.msgs, m)
+	}
+	return nil
+}
+
+func (r *memRepo) Pending(ctx context.Context, limit int) ([]Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []Message
+	for _, m := range r.msgs {
+		if m.Status == StatusPending && len(res) < limit {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func (r *memRepo) MarkSent(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.find(id).Status = StatusSent
+	return nil
+}
+
+func (r *memRepo) MarkFailed(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.find(id).Status = StatusFailed
+	return nil
+}
+
+func (r *memRepo) IncrAttempts(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.find(id).Attempts++
+	return nil
+}
+
+func (r *memRepo) find(id int64) *Message {
+	for i := range r.msgs {
+		if r.msgs[i].Id == id {
+			return &r.msgs[i]
+		}
+	}
+	panic("not found")
+}
+
+// fakeSender 值在 fail 里面的消息发送失败，成功的按顺序记下来
+type fakeSender struct {
+	fail map[string]bool
+	sent []string
+}
+
+func (s *fakeSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	val, _ := msg.Value.Encode()
+	if s.fail[string(val)] {
+		return errors.New("kafka 挂了")
+	}
+	s.sent = append(s.sent, string(val))
+	return nil
+}
+
+func newMsg(id int64, key, val string) Message {
+	m := Message{Id: id, Topic: "t", Value: []byte(val)}
+	if key != "" {
+		m.Key = []byte(key)
+	}
+	return m
+}
+
+func TestRelayDrain(t *testing.T) {
+	testCases := []struct {
+		name      string
+		msgs      []Message
+		fail      map[string]bool
+		wantEmpty bool
+		wantSent  []string
+		// 重试次数用完，标记成失败的
+		wantFailed []int64
+	}{
+		{
+			name: "全部发送成功",
+			msgs: []Message{
+				newMsg(1, "a", "a1"), newMsg(2, "b", "b1"), newMsg(3, "a", "a2"),
+			},
+			wantEmpty: true,
+			wantSent:  []string{"a1", "b1", "a2"},
+		},
+		{
+			name: "试探失败，这一轮都不发",
+			msgs: []Message{
+				newMsg(1, "a", "a1"), newMsg(2, "b", "b1"),
+			},
+			fail:     map[string]bool{"a1": true},
+			wantSent: nil,
+		},
+		{
+			name: "同一个 key 前面的失败了，后面的不发",
+			msgs: []Message{
+				newMsg(1, "a", "a1"), newMsg(2, "b", "b1"), newMsg(3, "b", "b2"),
+				newMsg(4, "a", "a2"), newMsg(5, "", "n1"),
+			},
+			fail:     map[string]bool{"b1": true},
+			wantSent: []string{"a1", "a2", "n1"},
+		},
+		{
+			name: "没有 key 的消息失败了不影响别的",
+			msgs: []Message{
+				newMsg(1, "a", "a1"), newMsg(2, "", "n1"), newMsg(3, "", "n2"),
+			},
+			fail:     map[string]bool{"n1": true},
+			wantSent: []string{"a1", "n2"},
+		},
+		{
+			name: "第一条重试次数用完了，后面的能发出去就把它标记成失败",
+			msgs: []Message{
+				exhaustedMsg(1, "a", "a1"), newMsg(2, "a", "a2"), newMsg(3, "b", "b1"),
+			},
+			fail: map[string]bool{"a1": true},
+			// a2 这一轮被 a1 挡住了，下一轮再发
+			wantSent:   []string{"b1"},
+			wantFailed: []int64{1},
+		},
+		{
+			name: "第一条重试次数用完了，但是 Kafka 挂了，不标记",
+			msgs: []Message{
+				exhaustedMsg(1, "a", "a1"), newMsg(2, "b", "b1"), newMsg(3, "c", "c1"),
+			},
+			fail: map[string]bool{"a1": true, "b1": true, "c1": true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &memRepo{msgs: append([]Message(nil), tc.msgs...)}
+			sender := &fakeSender{fail: tc.fail}
+			r := NewRelay(repo, sender, logger.NewZapLogger(zap.NewNop()))
+			empty, err := r.Drain(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantEmpty, empty)
+			assert.Equal(t, tc.wantSent, sender.sent)
+			var failed []int64
+			for i, m := range repo.msgs {
+				if m.Status == StatusFailed {
+					failed = append(failed, m.Id)
+				}
+				if tc.fail[string(m.Value)] && (i == 0 || tc.wantSent != nil) {
+					assert.Equal(t, tc.msgs[i].Attempts+1, m.Attempts)
+				}
+			}
+			assert.Equal(t, tc.wantFailed, failed)
+		})
+	}
+}
+
+func TestRelayDrainPoisonMessage(t *testing.T) {
+	// 第一条消息永远发不出去，比如太大了
+	repo := &memRepo{msgs: []Message{newMsg(1, "a", "a1"), newMsg(2, "b", "b1")}}
+	sender := &fakeSender{fail: map[string]bool{"a1": true}}
+	r := NewRelay(repo, sender, logger.NewZapLogger(zap.NewNop()))
+	ctx := context.Background()
+	for i := 1; i < r.maxAttempts; i++ {
+		empty, err := r.Drain(ctx)
+		require.NoError(t, err)
+		assert.False(t, empty)
+		assert.Empty(t, sender.sent)
+	}
+	// 重试次数用完之后不再卡住后面的消息
+	_, err := r.Drain(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b1"}, sender.sent)
+	assert.Equal(t, StatusFailed, repo.find(1).Status)
+	empty, err := r.Drain(ctx)
+	require.NoError(t, err)
+	assert.True(t, empty)
+}
+
+// exhaustedMsg 再失败一次就用完了重试次数
+func exhaustedMsg(id int64, key, val string) Message {
+	m := newMsg(id, key, val)
+	m.Attempts = 9
+	return m
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := &sarama.ProducerMessage{
+		Topic: "user_events",
+		Key:   sarama.StringEncoder("123"),
+		Value: sarama.ByteEncoder(`{"uid":123}`),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("event-type"), Value: []byte("UserRegistered")},
+		},
+	}
+	m, err := NewMessage(msg)
+	require.NoError(t, err)
+	got, err := m.ProducerMessage()
+	require.NoError(t, err)
+	assert.Equal(t, msg.Topic, got.Topic)
+	key, _ := got.Key.Encode()
+	assert.Equal(t, []byte("123"), key)
+	val, _ := got.Value.Encode()
+	assert.Equal(t, []byte(`{"uid":123}`), val)
+	assert.Equal(t, msg.Headers, got.Headers)
+
+	// 没有 key 的消息还原之后也没有 key
+	m, err = NewMessage(&sarama.ProducerMessage{Topic: "t", Value: sarama.StringEncoder("v")})
+	require.NoError(t, err)
+	got, err = m.ProducerMessage()
+	require.NoError(t, err)
+	assert.Nil(t, got.Key)
+}
@@ -0,0 +1,181 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/IBM/sarama"
+	"gorm.io/gorm"
+
+	"gochuji/webook/pkg/logger"
+)
+
+const (
+	StatusPending uint8 = iota
+	StatusSent
+	// StatusFailed 重试次数用完了还发不出去，不再发送，留着人工处理
+	StatusFailed
+)
+
+// Message outbox 表里面的一行，就是一条还没发出去（或者已经发出去）的 Kafka 消息
+type Message struct {
+	Id    int64  `gorm:"primaryKey;autoIncrement"`
+	Topic string `gorm:"size:255;not null"`
+	// Key 为 nil 代表没有 key，这种消息之间不保证顺序
+	Key     []byte `gorm:"type:varbinary(255)"`
+	Value   []byte `gorm:"type:mediumblob"`
+	Headers []byte `gorm:"type:blob"`
+	// 按照 id 顺序扫描待发送的消息
+	Status   uint8 `gorm:"not null;default:0;index:idx_status_id,priority:1"`
+	Attempts int   `gorm:"not null;default:0"`
+	Ctime    int64
+	Utime    int64
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+type header struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// NewMessage 把 sarama 的消息转成 outbox 里面的一行
+func NewMessage(msg *sarama.ProducerMessage) (Message, error) {
+	res := Message{Topic: msg.Topic}
+	var err error
+	if msg.Key != nil {
+		if res.Key, err = msg.Key.Encode(); err != nil {
+			return Message{}, err
+		}
+	}
+	if msg.Value != nil {
+		if res.Value, err = msg.Value.Encode(); err != nil {
+			return Message{}, err
+		}
+	}
+	if len(msg.Headers) > 0 {
+		hs := make([]header, 0, len(msg.Headers))
+		for _, h := range msg.Headers {
+			hs = append(hs, header{Key: h.Key, Value: h.Value})
+		}
+		if res.Headers, err = json.Marshal(hs); err != nil {
+			return Message{}, err
+		}
+	}
+	return res, nil
+}
+
+// ProducerMessage 还原成 sarama 的消息
+func (m Message) ProducerMessage() (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{
+		Topic: m.Topic,
+		Value: sarama.ByteEncoder(m.Value),
+	}
+	if m.Key != nil {
+		msg.Key = sarama.ByteEncoder(m.Key)
+	}
+	if len(m.Headers) > 0 {
+		var hs []header
+		if err := json.Unmarshal(m.Headers, &hs); err != nil {
+			return nil, err
+		}
+		for _, h := range hs {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+	return msg, nil
+}
+
+// Store outbox 表的操作
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+func InitTable(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{})
+}
+
+func (s *Store) Insert(ctx context.Context, msgs ...Message) error {
+	return InsertTx(s.db.WithContext(ctx), msgs...)
+}
+
+// InsertTx 在调用方的事务里面写 outbox，和业务数据一起提交或者回滚
+func InsertTx(tx *gorm.DB, msgs ...Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	for i := range msgs {
+		msgs[i].Status = StatusPending
+		msgs[i].Ctime = now
+		msgs[i].Utime = now
+	}
+	return tx.Create(&msgs).Error
+}
+
+// Pending 按照写入的顺序返回待发送的消息
+func (s *Store) Pending(ctx context.Context, limit int) ([]Message, error) {
+	var msgs []Message
+	err := s.db.WithContext(ctx).Where("status = ?", StatusPending).
+		Order("id").Limit(limit).Find(&msgs).Error
+	return msgs, err
+}
+
+func (s *Store) MarkSent(ctx context.Context, id int64) error {
+	return s.db.WithContext(ctx).Model(&Message{}).Where("id = ?", id).
+		Updates(map[string]any{
+			"status": StatusSent,
+			"utime":  time.Now().UnixMilli(),
+		}).Error
+}
+
+func (s *Store) MarkFailed(ctx context.Context, id int64) error {
+	return s.db.WithContext(ctx).Model(&Message{}).Where("id = ?", id).
+		Updates(map[string]any{
+			"status": StatusFailed,
+			"utime":  time.Now().UnixMilli(),
+		}).Error
+}
+
+func (s *Store) IncrAttempts(ctx context.Context, id int64) error {
+	return s.db.WithContext(ctx).Model(&Message{}).Where("id = ?", id).
+		Updates(map[string]any{
+			"attempts": gorm.Expr("attempts + 1"),
+			"utime":    time.Now().UnixMilli(),
+		}).Error
+}
+
+// Cleanup 删掉 before 之前已经发出去的消息，返回删掉的条数，由定时任务调用
+func (s *Store) Cleanup(ctx context.Context, before time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).
+		Where("status = ? AND utime < ?", StatusSent, before.UnixMilli()).
+		Delete(&Message{})
+	return res.RowsAffected, res.Error
+}
+
+// RunCleanup 每隔 interval 删掉发出去超过 retention 的消息，直到 ctx 结束
+func (s *Store) RunCleanup(ctx context.Context, retention, interval time.Duration, l logger.LoggerV1) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.Cleanup(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			l.Error("清理 outbox 失败", logger.Error(err))
+		case n > 0:
+			l.Info("清理 outbox", logger.Int64("deleted", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BreachedChecker 判断密码是不是出现在泄露库里面
+type BreachedChecker interface {
+	IsBreached(ctx context.Context, pwd string) (bool, error)
+}
+
+// RangeSource 按照 k-匿名的方式查询：只给出 SHA-1 的前 5 位，返回同前缀的所有后缀。
+// 本地文件和 HIBP 的 range 接口都可以实现它，密码本身不会离开本机
+type RangeSource interface {
+	Range(ctx context.Context, prefix string) ([]string, error)
+}
+
+// PrefixChecker 基于 RangeSource 的 BreachedChecker
+type PrefixChecker struct {
+	src RangeSource
+}
+
+func NewPrefixChecker(src RangeSource) *PrefixChecker {
+	return &PrefixChecker{src: src}
+}
+
+func (c *PrefixChecker) IsBreached(ctx context.Context, pwd string) (bool, error) {
+	sum := sha1.Sum([]byte(pwd))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := c.src.Range(ctx, h[:5])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == h[5:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//go:embed breached.txt
+var bundledBreached []byte
+
+// FileRangeSource 离线的 RangeSource，每一行是 前5位:剩余35位，# 开头的是注释
+type FileRangeSource struct {
+	buckets map[string][]string
+}
+
+// NewBundledRangeSource 使用打包进二进制里面的常见密码列表
+func NewBundledRangeSource() *FileRangeSource {
+	src, err := NewFileRangeSource(bytes.NewReader(bundledBreached))
+	if err != nil {
+		// 打包进来的文件坏了，说明构建有问题
+		panic(err)
+	}
+	return src
+}
+
+func NewFileRangeSource(r io.Reader) (*FileRangeSource, error) {
+	buckets := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, suffix, ok := strings.Cut(line, ":")
+		if !ok || len(prefix) != 5 || len(suffix) != 35 {
+			return nil, fmt.Errorf("泄露密码文件第 %d 行格式不对", lineNo)
+		}
+		prefix = strings.ToUpper(prefix)
+		buckets[prefix] = append(buckets[prefix], strings.ToUpper(suffix))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &FileRangeSource{buckets: buckets}, nil
+}
+
+func (s *FileRangeSource) Range(ctx context.Context, prefix string) ([]string, error) {
+	return s.buckets[strings.ToUpper(prefix)], nil
+}
@@ -0,0 +1,238 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch      = errors.New("密码不匹配")
+	ErrUnknownHash   = errors.New("不认识的密码哈希格式")
+	ErrMalformedHash = errors.New("密码哈希格式不对")
+)
+
+// Hasher 密码哈希算法。哈希结果都是自描述的字符串，
+// 里面带着算法和参数，所以换算法之后老的哈希还能校验
+type Hasher interface {
+	Hash(pwd string) (string, error)
+	// Verify 不匹配的时候返回 ErrMismatch
+	Verify(encoded string, pwd string) error
+	// Supports 这个哈希是不是这个算法生成的
+	Supports(encoded string) bool
+	// NeedsRehash 参数和现在的配置不一致，需要重新哈希
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher bcrypt 本身的 $2a$cost$... 格式就带了算法和 cost，直接沿用，
+// 这样老数据不需要做任何转换
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(pwd string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), h.cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(encoded string, pwd string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(pwd))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (h *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost
+}
+
+// Argon2idParams argon2id 的参数，Memory 的单位是 KiB
+type Argon2idParams struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2idParams OWASP 推荐的最低配置：19 MiB 内存，迭代 2 次，1 个线程
+func DefaultArgon2idParams() Argon2idParams {
+	return Argon2idParams{
+		Memory:  19 * 1024,
+		Time:    2,
+		Threads: 1,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+// Argon2idHasher 哈希结果是 PHC 字符串：
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (h *Argon2idHasher) Hash(pwd string) (string, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(pwd), salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded string, pwd string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	actual := argon2.IDKey([]byte(pwd), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.params.Memory ||
+		params.Time != h.params.Time ||
+		params.Threads != h.params.Threads ||
+		uint32(len(salt)) != h.params.SaltLen ||
+		uint32(len(key)) != h.params.KeyLen
+}
+
+// 数据库里面的哈希参数不可信：t、p 为 0 的时候 argon2 会 panic，太大的话一次登录就能把机器拖垮
+const (
+	maxArgon2Memory  = 1 << 20 // 1 GiB
+	maxArgon2Time    = 16
+	maxArgon2Threads = 16
+	maxArgon2KeyLen  = 1024
+)
+
+func checkArgon2idParams(params Argon2idParams) error {
+	switch {
+	case params.Time == 0 || params.Time > maxArgon2Time:
+		return fmt.Errorf("%w: 迭代次数 t=%d 不合法", ErrMalformedHash, params.Time)
+	case params.Threads == 0 || params.Threads > maxArgon2Threads:
+		return fmt.Errorf("%w: 线程数 p=%d 不合法", ErrMalformedHash, params.Threads)
+	case params.Memory < 8*uint32(params.Threads) || params.Memory > maxArgon2Memory:
+		return fmt.Errorf("%w: 内存 m=%d 不合法", ErrMalformedHash, params.Memory)
+	}
+	return nil
+}
+
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	// 第一段是空的，因为字符串以 $ 开头
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: 不支持的 argon2 版本 %d", ErrMalformedHash, version)
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if err = checkArgon2idParams(params); err != nil {
+		return params, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 || len(key) > maxArgon2KeyLen {
+		return params, nil, nil, ErrMalformedHash
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// MultiHasher 用 primary 生成新的哈希，校验的时候按照哈希的前缀找到对应的算法。
+// 不是 primary 生成的哈希都需要重新哈希，这样老用户登录一次就迁移过来了
+type MultiHasher struct {
+	primary Hasher
+	legacy  []Hasher
+}
+
+func NewMultiHasher(primary Hasher, legacy ...Hasher) *MultiHasher {
+	return &MultiHasher{
+		primary: primary,
+		legacy:  legacy,
+	}
+}
+
+func (m *MultiHasher) Hash(pwd string) (string, error) {
+	return m.primary.Hash(pwd)
+}
+
+func (m *MultiHasher) Verify(encoded string, pwd string) error {
+	h := m.find(encoded)
+	if h == nil {
+		return ErrUnknownHash
+	}
+	return h.Verify(encoded, pwd)
+}
+
+func (m *MultiHasher) Supports(encoded string) bool {
+	return m.find(encoded) != nil
+}
+
+func (m *MultiHasher) NeedsRehash(encoded string) bool {
+	if !m.primary.Supports(encoded) {
+		return true
+	}
+	return m.primary.NeedsRehash(encoded)
+}
+
+func (m *MultiHasher) find(encoded string) Hasher {
+	if m.primary.Supports(encoded) {
+		return m.primary
+	}
+	for _, h := range m.legacy {
+		if h.Supports(encoded) {
+			return h
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	h := NewArgon2idHasher(DefaultArgon2idParams())
+	encoded, err := h.Hash("hello#world9")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	assert.NoError(t, h.Verify(encoded, "hello#world9"))
+	assert.Equal(t, ErrMismatch, h.Verify(encoded, "hello#world8"))
+	assert.False(t, h.NeedsRehash(encoded))
+
+	// 调高了参数就需要重新哈希
+	stronger := DefaultArgon2idParams()
+	stronger.Time = 3
+	assert.True(t, NewArgon2idHasher(stronger).NeedsRehash(encoded))
+
+	assert.Equal(t, ErrMalformedHash, h.Verify("$argon2id$v=19$m=1$abc", "hello#world9"))
+}
+
+func TestMultiHasher(t *testing.T) {
+	bh := NewBcryptHasher(bcrypt.MinCost)
+	legacy, err := bh.Hash("hello#world9")
+	require.NoError(t, err)
+
+	m := NewMultiHasher(NewArgon2idHasher(DefaultArgon2idParams()), bh)
+	// 老的 bcrypt 哈希还能校验，但是需要迁移
+	assert.NoError(t, m.Verify(legacy, "hello#world9"))
+	assert.Equal(t, ErrMismatch, m.Verify(legacy, "hello#world8"))
+	assert.True(t, m.NeedsRehash(legacy))
+
+	encoded, err := m.Hash("hello#world9")
+	require.NoError(t, err)
+	assert.NoError(t, m.Verify(encoded, "hello#world9"))
+	assert.False(t, m.NeedsRehash(encoded))
+
+	assert.Equal(t, ErrUnknownHash, m.Verify("$scrypt$xxx", "hello#world9"))
+}
+
+func TestArgon2idHasher_MalformedParams(t *testing.T) {
+	h := NewArgon2idHasher(DefaultArgon2idParams())
+	const rest = "$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaA"
+	testCases := []struct {
+		name   string
+		params string
+	}{
+		{name: "t 为 0", params: "m=19456,t=0,p=1"},
+		{name: "p 为 0", params: "m=19456,t=2,p=0"},
+		{name: "m 小于 8p", params: "m=15,t=2,p=2"},
+		{name: "m 太大", params: "m=4294967295,t=2,p=1"},
+		{name: "t 太大", params: "m=19456,t=1000000,p=1"},
+		{name: "p 太大", params: "m=19456,t=2,p=255"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded := "$argon2id$v=19$" + tc.params + rest
+			// 不能 panic，也不能跑很久
+			assert.ErrorIs(t, h.Verify(encoded, "hello#world9"), ErrMalformedHash)
+			assert.True(t, h.NeedsRehash(encoded))
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrTooShort     = errors.New("密码太短")
+	ErrTooLong      = errors.New("密码太长")
+	ErrMissingClass = errors.New("密码缺少必须的字符类型")
+	ErrBlocked      = errors.New("密码在禁用列表里面")
+	ErrBreached     = errors.New("密码出现在泄露库里面，请换一个")
+)
+
+// Policy 密码策略
+type Policy struct {
+	MinLength int
+	// bcrypt 只看前 72 个字节，超过的部分等于没有
+	MaxLength int
+
+	RequireLetter  bool
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+	// 哪些字符算特殊字符
+	Specials string
+
+	// Blocklist 禁止使用的密码，不区分大小写
+	Blocklist []string
+	// Breached 为 nil 就不检查泄露库
+	Breached BreachedChecker
+}
+
+// DefaultPolicy 和原来注册时候的正则保持一致：
+// 必须包含字母、数字、特殊字符，并且不少于八位
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:      8,
+		MaxLength:      72,
+		RequireLetter:  true,
+		RequireDigit:   true,
+		RequireSpecial: true,
+		Specials:       "$@!%*#?&",
+		Breached:       NewPrefixChecker(NewBundledRangeSource()),
+	}
+}
+
+// CheckFormat 只做本地的格式校验：长度、字符类型和禁用列表。
+// 适合放在请求参数校验里面
+func (p *Policy) CheckFormat(pwd string) error {
+	length := utf8.RuneCountInString(pwd)
+	if length < p.MinLength {
+		return ErrTooShort
+	}
+	if p.MaxLength > 0 && len(pwd) > p.MaxLength {
+		return ErrTooLong
+	}
+
+	var letter, upper, lower, digit, special bool
+	for _, r := range pwd {
+		switch {
+		case unicode.IsUpper(r):
+			letter, upper = true, true
+		case unicode.IsLower(r):
+			letter, lower = true, true
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		case strings.ContainsRune(p.Specials, r):
+			special = true
+		}
+	}
+	if (p.RequireLetter && !letter) || (p.RequireUpper && !upper) ||
+		(p.RequireLower && !lower) || (p.RequireDigit && !digit) ||
+		(p.RequireSpecial && !special) {
+		return ErrMissingClass
+	}
+
+	for _, blocked := range p.Blocklist {
+		if strings.EqualFold(pwd, blocked) {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// Check 完整的校验，在 CheckFormat 的基础上再查一下泄露库
+func (p *Policy) Check(ctx context.Context, pwd string) error {
+	if err := p.CheckFormat(pwd); err != nil {
+		return err
+	}
+	if p.Breached == nil {
+		return nil
+	}
+	breached, err := p.Breached.IsBreached(ctx, pwd)
+	if err != nil {
+		return fmt.Errorf("查询泄露库失败: %w", err)
+	}
+	if breached {
+		return ErrBreached
+	}
+	return nil
+}
+
+// Describe 给用户看的策略说明
+func (p *Policy) Describe() string {
+	var classes []string
+	if p.RequireUpper {
+		classes = append(classes, "大写字母")
+	}
+	if p.RequireLower {
+		classes = append(classes, "小写字母")
+	}
+	if p.RequireLetter && !p.RequireUpper && !p.RequireLower {
+		classes = append(classes, "字母")
+	}
+	if p.RequireDigit {
+		classes = append(classes, "数字")
+	}
+	if p.RequireSpecial {
+		classes = append(classes, fmt.Sprintf("特殊字符(%s)", p.Specials))
+	}
+	if len(classes) == 0 {
+		return fmt.Sprintf("密码不少于%d位", p.MinLength)
+	}
+	return fmt.Sprintf("密码必须包含%s，并且不少于%d位", strings.Join(classes, "、"), p.MinLength)
+}
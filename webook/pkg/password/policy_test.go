@@ -0,0 +1,44 @@
+package password
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	p := DefaultPolicy()
+	p.Blocklist = []string{"Webook@2025"}
+
+	testCases := []struct {
+		name    string
+		pwd     string
+		wantErr error
+	}{
+		{name: "合法密码", pwd: "hello#world9"},
+		{name: "太短", pwd: "h#1", wantErr: ErrTooShort},
+		{name: "太长", pwd: "h#1" + strings.Repeat("a", 70), wantErr: ErrTooLong},
+		{name: "缺少特殊字符", pwd: "helloworld9", wantErr: ErrMissingClass},
+		{name: "缺少数字", pwd: "hello#world", wantErr: ErrMissingClass},
+		{name: "禁用列表不区分大小写", pwd: "webook@2025", wantErr: ErrBlocked},
+		{name: "泄露库", pwd: "P@ssw0rd", wantErr: ErrBreached},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := p.Check(context.Background(), tc.pwd)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestFileRangeSource(t *testing.T) {
+	_, err := NewFileRangeSource(strings.NewReader("ABCDE:123\n"))
+	assert.Error(t, err)
+
+	src := NewBundledRangeSource()
+	breached, err := NewPrefixChecker(src).IsBreached(context.Background(), "Admin@123")
+	assert.NoError(t, err)
+	assert.True(t, breached)
+}
@@ -0,0 +1,152 @@
+package ringBuffer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// HealthSnapshot 同一时刻的样本数、成功数和成功率
+type HealthSnapshot struct {
+	Count     int
+	Successes int
+	Rate      float64
+}
+
+// Healthy 样本不够的时候认为是健康的
+func (s HealthSnapshot) Healthy(minSuccessRate float64, minSamples int) bool {
+	return s.Count < minSamples || s.Rate >= minSuccessRate
+}
+
+func newSnapshot(count, successes int) HealthSnapshot {
+	rate := 1.0 // 无数据默认100%
+	if count > 0 {
+		rate = float64(successes) / float64(count)
+	}
+	return HealthSnapshot{Count: count, Successes: successes, Rate: rate}
+}
+
+// BitHealthBuffer 大小可以配置的比特环形缓冲区，记录最近 size 次的成功/失败。
+// 写入的时候维护成功数，SuccessRate 不用再去数比特位
+type BitHealthBuffer struct {
+	mutex     sync.Mutex
+	bits      []uint64
+	size      int
+	next      int // 下一个写入的位置，满了之后也是最旧的样本的位置
+	count     int
+	successes int
+}
+
+// NewBitHealthBuffer size 小于 1 的按 1 处理，不然 Push 的时候取模会除以 0
+func NewBitHealthBuffer(size int) *BitHealthBuffer {
+	size = max(size, 1)
+	return &BitHealthBuffer{
+		bits: make([]uint64, (size+63)/64),
+		size: size,
+	}
+}
+
+func (b *BitHealthBuffer) Push(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	word, mask := b.next/64, uint64(1)<<(b.next%64)
+	if b.count == b.size {
+		// 覆盖最旧的样本
+		if b.bits[word]&mask != 0 {
+			b.successes--
+		}
+	} else {
+		b.count++
+	}
+	if success {
+		b.bits[word] |= mask
+		b.successes++
+	} else {
+		b.bits[word] &^= mask
+	}
+	b.next = (b.next + 1) % b.size
+}
+
+func (b *BitHealthBuffer) Snapshot() HealthSnapshot {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return newSnapshot(b.count, b.successes)
+}
+
+func (b *BitHealthBuffer) SuccessRate() float64 {
+	return b.Snapshot().Rate
+}
+
+func (b *BitHealthBuffer) IsHealthy(minSuccessRate float64, minSamples int) bool {
+	return b.Snapshot().Healthy(minSuccessRate, minSamples)
+}
+
+// Reset 清空所有样本
+func (b *BitHealthBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	clear(b.bits)
+	b.next, b.count, b.successes = 0, 0, 0
+}
+
+// AtomicBitHealthBuffer 无锁版本。每次写入用一个递增的序号占一个位置，
+// 再用原子的 Or/And 改比特位，根据旧的比特位修正成功数。
+// Snapshot 分别读序号和成功数，并发写入的时候两者可能差几个样本，只能当作近似值
+type AtomicBitHealthBuffer struct {
+	bits      []atomic.Uint64
+	size      uint64
+	seq       atomic.Uint64
+	successes atomic.Int64
+}
+
+// NewAtomicBitHealthBuffer 和 NewBitHealthBuffer 一样，size 小于 1 的按 1 处理
+func NewAtomicBitHealthBuffer(size int) *AtomicBitHealthBuffer {
+	size = max(size, 1)
+	return &AtomicBitHealthBuffer{
+		bits: make([]atomic.Uint64, (size+63)/64),
+		size: uint64(size),
+	}
+}
+
+func (b *AtomicBitHealthBuffer) Push(success bool) {
+	seq := b.seq.Add(1) - 1
+	pos := seq % b.size
+	word, mask := &b.bits[pos/64], uint64(1)<<(pos%64)
+	var old uint64
+	var delta int64
+	if success {
+		old = word.Or(mask)
+		delta = 1
+	} else {
+		old = word.And(^mask)
+	}
+	// 第一圈的位置上原来没有样本
+	if seq >= b.size && old&mask != 0 {
+		delta--
+	}
+	if delta != 0 {
+		b.successes.Add(delta)
+	}
+}
+
+func (b *AtomicBitHealthBuffer) Snapshot() HealthSnapshot {
+	count := min(b.seq.Load(), b.size)
+	successes := min(max(b.successes.Load(), 0), int64(count))
+	return newSnapshot(int(count), int(successes))
+}
+
+func (b *AtomicBitHealthBuffer) SuccessRate() float64 {
+	return b.Snapshot().Rate
+}
+
+func (b *AtomicBitHealthBuffer) IsHealthy(minSuccessRate float64, minSamples int) bool {
+	return b.Snapshot().Healthy(minSuccessRate, minSamples)
+}
+
+// Reset 清空所有样本，和并发的 Push 一起调用的话结果不保证准确
+func (b *AtomicBitHealthBuffer) Reset() {
+	for i := range b.bits {
+		b.bits[i].Store(0)
+	}
+	b.seq.Store(0)
+	b.successes.Store(0)
+}
@@ -0,0 +1,169 @@
+package ringBuffer
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type healthBuffer interface {
+	Push(success bool)
+	Snapshot() HealthSnapshot
+	IsHealthy(minSuccessRate float64, minSamples int) bool
+	Reset()
+}
+
+func TestBitHealthBuffer(t *testing.T) {
+	impls := map[string]func(size int) healthBuffer{
+		"mutex":  func(size int) healthBuffer { return NewBitHealthBuffer(size) },
+		"atomic": func(size int) healthBuffer { return NewAtomicBitHealthBuffer(size) },
+	}
+	testCases := []struct {
+		name    string
+		size    int
+		pushes  []bool
+		wantCnt int
+		wantSuc int
+	}{
+		{name: "没有样本", size: 10, wantCnt: 0, wantSuc: 0},
+		{name: "没写满", size: 10, pushes: []bool{true, false, true}, wantCnt: 3, wantSuc: 2},
+		{
+			name:    "覆盖旧的失败",
+			size:    4,
+			pushes:  []bool{false, false, true, true, true, true},
+			wantCnt: 4,
+			wantSuc: 4,
+		},
+		{
+			name:    "覆盖旧的成功",
+			size:    4,
+			pushes:  []bool{true, true, true, true, false, false},
+			wantCnt: 4,
+			wantSuc: 2,
+		},
+		{
+			// 不是 64 的整数倍
+			name:    "跨越多个字",
+			size:    100,
+			pushes:  append(repeat(true, 150), repeat(false, 30)...),
+			wantCnt: 100,
+			wantSuc: 70,
+		},
+	}
+	for name, newBuf := range impls {
+		for _, tc := range testCases {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				buf := newBuf(tc.size)
+				for _, p := range tc.pushes {
+					buf.Push(p)
+				}
+				s := buf.Snapshot()
+				assert.Equal(t, tc.wantCnt, s.Count)
+				assert.Equal(t, tc.wantSuc, s.Successes)
+				if tc.wantCnt > 0 {
+					assert.InDelta(t, float64(tc.wantSuc)/float64(tc.wantCnt), s.Rate, 1e-9)
+				} else {
+					assert.Equal(t, 1.0, s.Rate)
+				}
+
+				buf.Reset()
+				assert.Equal(t, HealthSnapshot{Rate: 1}, buf.Snapshot())
+			})
+		}
+	}
+}
+
+func TestBitHealthBuffer_InvalidSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		for _, buf := range []healthBuffer{NewBitHealthBuffer(size), NewAtomicBitHealthBuffer(size)} {
+			// 按 1 处理，只记最近一次
+			buf.Push(false)
+			buf.Push(true)
+			assert.Equal(t, HealthSnapshot{Count: 1, Successes: 1, Rate: 1}, buf.Snapshot())
+		}
+	}
+}
+
+func TestAtomicBitHealthBuffer_Concurrent(t *testing.T) {
+	const size, goroutines, pushes = 100, 8, 1000
+	buf := NewAtomicBitHealthBuffer(size)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < pushes; i++ {
+				buf.Push((g+i)%3 != 0)
+				// 边写边读，成功数不能超过样本数
+				s := buf.Snapshot()
+				assert.LessOrEqual(t, s.Successes, s.Count)
+				assert.LessOrEqual(t, s.Count, size)
+				assert.GreaterOrEqual(t, s.Successes, 0)
+			}
+		}(g)
+	}
+	wg.Wait()
+	s := buf.Snapshot()
+	assert.Equal(t, size, s.Count)
+	assert.LessOrEqual(t, s.Successes, s.Count)
+
+	// 停下来之后写满一圈，第一圈并发的时候可能多算几个成功，只能是近似值
+	for i := 0; i < size; i++ {
+		buf.Push(i%4 != 0)
+	}
+	s = buf.Snapshot()
+	assert.Equal(t, size, s.Count)
+	assert.InDelta(t, 0.75, s.Rate, 0.05)
+}
+
+func TestHealthSnapshot_Healthy(t *testing.T) {
+	assert.True(t, HealthSnapshot{Count: 5, Rate: 0}.Healthy(0.9, 10))
+	assert.False(t, HealthSnapshot{Count: 10, Rate: 0.8}.Healthy(0.9, 10))
+	assert.True(t, HealthSnapshot{Count: 10, Rate: 0.9}.Healthy(0.9, 10))
+}
+
+func repeat(v bool, n int) []bool {
+	res := make([]bool, n)
+	for i := range res {
+		res[i] = v
+	}
+	return res
+}
+
+func BenchmarkHealthBuffer_Push(b *testing.B) {
+	impls := map[string]healthBuffer{
+		"mutex":  NewBitHealthBuffer(1024),
+		"atomic": NewAtomicBitHealthBuffer(1024),
+	}
+	for name, buf := range impls {
+		b.Run(name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					buf.Push(i%20 != 0)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkHealthBuffer_IsHealthy(b *testing.B) {
+	impls := map[string]healthBuffer{
+		"mutex":  NewBitHealthBuffer(1024),
+		"atomic": NewAtomicBitHealthBuffer(1024),
+	}
+	for name, buf := range impls {
+		for i := 0; i < 2000; i++ {
+			buf.Push(i%20 != 0)
+		}
+		b.Run(name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					buf.IsHealthy(0.9, 100)
+				}
+			})
+		})
+	}
+}
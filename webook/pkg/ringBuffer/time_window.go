@@ -0,0 +1,146 @@
+package ringBuffer
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyBounds 延迟分桶的上界，最后一个桶放所有更慢的请求
+var latencyBounds = [...]time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// latencyBuckets 延迟分桶的个数，比上界多一个
+const latencyBuckets = len(latencyBounds) + 1
+
+type timeBucket struct {
+	// 这个桶对应的时间段的序号，也就是 unix 时间除以 interval，用来判断桶是不是过期了
+	epoch     int64
+	successes int
+	failures  int
+	latencies [latencyBuckets]int
+}
+
+// WindowSnapshot 最近一个窗口的统计
+type WindowSnapshot struct {
+	Requests  int
+	Failures  int
+	ErrorRate float64
+	// 分桶估计出来的延迟，取所在桶的上界，超过最大的桶就是最大的上界
+	P50 time.Duration
+	P99 time.Duration
+}
+
+// Healthy 和 HealthSnapshot.Healthy 一样，样本不够的时候认为是健康的
+func (s WindowSnapshot) Healthy(minSuccessRate float64, minSamples int) bool {
+	return s.Requests < minSamples || 1-s.ErrorRate >= minSuccessRate
+}
+
+// TimeHealthWindow 按时间滑动的窗口，分成 buckets 个桶，每 interval 换一个桶。
+// 和 BitHealthBuffer 不同，很久之前的失败会随着时间过期，不会一直影响安静的服务
+type TimeHealthWindow struct {
+	mutex    sync.Mutex
+	buckets  []timeBucket
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewTimeHealthWindow buckets 小于 1 的按 1 处理，interval 不是正数的按 1 秒处理，
+// 不然 Record 的时候会除以 0
+func NewTimeHealthWindow(buckets int, interval time.Duration) *TimeHealthWindow {
+	buckets = max(buckets, 1)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &TimeHealthWindow{
+		buckets:  make([]timeBucket, buckets),
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+func (w *TimeHealthWindow) Record(success bool, latency time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	epoch := w.epoch()
+	b := &w.buckets[epoch%int64(len(w.buckets))]
+	if b.epoch != epoch {
+		// 上一次用这个桶已经是一圈之前了
+		*b = timeBucket{epoch: epoch}
+	}
+	if success {
+		b.successes++
+	} else {
+		b.failures++
+	}
+	b.latencies[latencyIndex(latency)]++
+}
+
+func (w *TimeHealthWindow) Snapshot() WindowSnapshot {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	epoch := w.epoch()
+	oldest := epoch - int64(len(w.buckets)) + 1
+	var s WindowSnapshot
+	var latencies [latencyBuckets]int
+	for _, b := range w.buckets {
+		if b.epoch < oldest || b.epoch > epoch {
+			continue
+		}
+		s.Requests += b.successes + b.failures
+		s.Failures += b.failures
+		for i, n := range b.latencies {
+			latencies[i] += n
+		}
+	}
+	if s.Requests == 0 {
+		return s
+	}
+	s.ErrorRate = float64(s.Failures) / float64(s.Requests)
+	s.P50 = quantile(latencies[:], s.Requests, 0.5)
+	s.P99 = quantile(latencies[:], s.Requests, 0.99)
+	return s
+}
+
+func (w *TimeHealthWindow) IsHealthy(minSuccessRate float64, minSamples int) bool {
+	return w.Snapshot().Healthy(minSuccessRate, minSamples)
+}
+
+// Reset 清空所有桶
+func (w *TimeHealthWindow) Reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	clear(w.buckets)
+}
+
+func (w *TimeHealthWindow) epoch() int64 {
+	return w.now().UnixNano() / int64(w.interval)
+}
+
+func latencyIndex(latency time.Duration) int {
+	for i, bound := range latencyBounds {
+		if latency <= bound {
+			return i
+		}
+	}
+	return len(latencyBounds)
+}
+
+func quantile(latencies []int, total int, q float64) time.Duration {
+	// 第 rank 个请求落在哪个桶里面
+	rank := int(float64(total)*q + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, n := range latencies {
+		seen += n
+		if seen >= rank && i < len(latencyBounds) {
+			return latencyBounds[i]
+		}
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
@@ -0,0 +1,65 @@
+package ringBuffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeHealthWindow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := NewTimeHealthWindow(6, 10*time.Second)
+	w.now = func() time.Time { return now }
+
+	// 第一个桶：10 个失败
+	for i := 0; i < 10; i++ {
+		w.Record(false, 300*time.Millisecond)
+	}
+	now = now.Add(30 * time.Second)
+	// 第四个桶：90 个成功
+	for i := 0; i < 90; i++ {
+		w.Record(true, 3*time.Millisecond)
+	}
+	s := w.Snapshot()
+	assert.Equal(t, 100, s.Requests)
+	assert.Equal(t, 10, s.Failures)
+	assert.InDelta(t, 0.1, s.ErrorRate, 1e-9)
+	assert.Equal(t, 5*time.Millisecond, s.P50)
+	assert.Equal(t, 500*time.Millisecond, s.P99)
+	assert.True(t, w.IsHealthy(0.9, 50))
+	assert.False(t, w.IsHealthy(0.95, 50))
+
+	// 一分钟之后第一个桶过期了，之前的失败不再算
+	now = now.Add(30 * time.Second)
+	s = w.Snapshot()
+	assert.Equal(t, 90, s.Requests)
+	assert.Equal(t, 0, s.Failures)
+	assert.True(t, w.IsHealthy(0.95, 50))
+
+	// 再过一分钟全部过期，样本不够，认为是健康的
+	now = now.Add(time.Minute)
+	assert.Equal(t, WindowSnapshot{}, w.Snapshot())
+	assert.True(t, w.IsHealthy(0.99, 1))
+
+	w.Record(false, 20*time.Second)
+	assert.Equal(t, 10*time.Second, w.Snapshot().P99)
+	w.Reset()
+	assert.Equal(t, 0, w.Snapshot().Requests)
+}
+
+func TestTimeHealthWindow_InvalidConfig(t *testing.T) {
+	for _, w := range []*TimeHealthWindow{
+		NewTimeHealthWindow(0, time.Second),
+		NewTimeHealthWindow(-1, time.Second),
+		NewTimeHealthWindow(6, 0),
+		NewTimeHealthWindow(6, -time.Second),
+	} {
+		// 不会除以 0
+		w.Record(false, time.Millisecond)
+		w.Record(true, time.Millisecond)
+		s := w.Snapshot()
+		assert.Equal(t, 2, s.Requests)
+		assert.Equal(t, 1, s.Failures)
+	}
+}
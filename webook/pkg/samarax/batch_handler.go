@@ -2,20 +2,32 @@ package samarax
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/IBM/sarama"
 	"gochuji/webook/pkg/logger"
-	"log"
-	"time"
 )
 
+// BatchError fn 可以返回它来说明批次里面哪些消息失败了，
+// key 是传给 fn 的 msgs 的下标。没有出现在里面的消息都算成功
+type BatchError map[int]error
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("批次里面有 %d 条消息处理失败", len(e))
+}
+
 type BatchHandler[T any] struct {
-	fn func(msgs []*sarama.ConsumerMessage, ts []T) error
-	l  logger.LoggerV1
+	fn    func(msgs []*sarama.ConsumerMessage, ts []T) error
+	l     logger.LoggerV1
+	opts  options
+	codec Codec[T]
 }
 
-func NewBatchHandler[T any](l logger.LoggerV1, fn func(msgs []*sarama.ConsumerMessage, ts []T) error) *BatchHandler[T] {
-	return &BatchHandler[T]{fn: fn, l: l}
+func NewBatchHandler[T any](l logger.LoggerV1, fn func(msgs []*sarama.ConsumerMessage, ts []T) error, opts ...Option) *BatchHandler[T] {
+	o := newOptions(opts)
+	return &BatchHandler[T]{fn: fn, l: l, opts: o, codec: codecOf[T](o)}
 }
 
 func (b *BatchHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
@@ -28,48 +40,180 @@ func (b *BatchHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 
 func (b *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	msgs := claim.Messages()
-	const batchSize = 10
+	ctx := session.Context()
 	for {
-		log.Println("一个批次开始")
-		batch := make([]*sarama.ConsumerMessage, 0, batchSize)
-		ts := make([]T, 0, batchSize)
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		var done = false
-		for i := 0; i < batchSize && !done; i++ {
-			select {
-			case <-ctx.Done():
-				// 超时了
-				done = true
-			case msg, ok := <-msgs:
-				if !ok {
-					cancel()
-					return nil
-				}
-				batch = append(batch, msg)
-				var t T
-				err := json.Unmarshal(msg.Value, &t)
-				if err != nil {
-					b.l.Error("反序列消息体失败",
-						logger.String("topic", msg.Topic),
-						logger.Int32("partition", msg.Partition),
-						logger.Int64("offset", msg.Offset),
-						logger.Error(err))
-					continue
-				}
-				batch = append(batch, msg)
-				ts = append(ts, t)
+		batch, ok := b.collect(ctx, msgs)
+		if len(batch) > 0 {
+			err := b.consume(ctx, session, batch)
+			if err != nil {
+				return err
+			}
+		}
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// collect 凑一批消息：条数、字节数够了，或者第一条消息到了之后等够了 maxLinger，就返回。
+// 第二个返回值是 false 代表 msgs 已经关闭或者 ctx 结束了
+func (b *BatchHandler[T]) collect(ctx context.Context, msgs <-chan *sarama.ConsumerMessage) ([]*sarama.ConsumerMessage, bool) {
+	// 第一条消息没来之前，没必要开始计时
+	var first *sarama.ConsumerMessage
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case msg, ok := <-msgs:
+		if !ok {
+			return nil, false
+		}
+		first = msg
+	}
+
+	batch := make([]*sarama.ConsumerMessage, 0, b.opts.batchSize)
+	batch = append(batch, first)
+	bytes := len(first.Value)
+	timer := time.NewTimer(b.opts.maxLinger)
+	defer timer.Stop()
+	for len(batch) < b.opts.batchSize && (b.opts.maxBytes <= 0 || bytes < b.opts.maxBytes) {
+		select {
+		case <-ctx.Done():
+			return batch, false
+		case <-timer.C:
+			// 超时了
+			return batch, true
+		case msg, ok := <-msgs:
+			if !ok {
+				return batch, false
 			}
+			batch = append(batch, msg)
+			bytes += len(msg.Value)
 		}
-		cancel()
-		// 凑够了一批，然后你就处理
-		err := b.fn(batch, ts)
+	}
+	return batch, true
+}
+
+// consume 处理一批消息，成功的和进了死信队列的都会提交。
+// 返回错误代表有消息既没处理成功也没进死信队列，这时候只提交它前面的消息
+func (b *BatchHandler[T]) consume(ctx context.Context, session sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage) error {
+	ctx, span := startBatchSpan(ctx, b.opts, batch)
+	defer func() {
+		forgetMessages(batch...)
+		span.End()
+	}()
+	m := b.opts.handlerMetrics
+	topic := batch[0].Topic
+	m.consume(topic, len(batch))
+	m.batch(topic, len(batch))
+	// done[i] 代表 batch[i] 已经处理完了，可以提交
+	done := make([]bool, len(batch))
+	// 反序列化成功的消息在 batch 里面的下标，和 ts 一一对应
+	idxs := make([]int, 0, len(batch))
+	ts := make([]T, 0, len(batch))
+	// 每条消息自己的 logger，带上了消息的位置
+	ls := make([]logger.LoggerV1, len(batch))
+	for i, msg := range batch {
+		_, ls[i] = messageContext(ctx, b.l, msg)
+	}
+	var dlqErr error
+	for i, msg := range batch {
+		t, err := b.codec.Decode(msg.Value, msg.Headers)
 		if err != nil {
-			b.l.Error("处理消息失败",
-				// 把真个 msgs 都记录下来
+			recordError(span, err)
+			m.fail(topic)
+			ls[i].Error("反序列消息体失败", logger.Error(err))
+			err = sendDeadLetter(ls[i], b.opts, msg, err, 0)
+			if err != nil {
+				dlqErr = err
+			}
+			done[i] = err == nil
+			continue
+		}
+		if seen(ctx, ls[i], b.opts.dedupe, msg) {
+			done[i] = true
+			continue
+		}
+		idxs = append(idxs, i)
+		ts = append(ts, t)
+	}
+
+	for attempt := 1; len(idxs) > 0; attempt++ {
+		msgs := make([]*sarama.ConsumerMessage, 0, len(idxs))
+		for _, idx := range idxs {
+			msgs = append(msgs, batch[idx])
+		}
+		failed := batchFailures(b.fn(msgs, ts), len(msgs))
+
+		retryIdxs := make([]int, 0, len(failed))
+		retryTs := make([]T, 0, len(failed))
+		for i, idx := range idxs {
+			err, ok := failed[i]
+			if !ok {
+				markSeen(ctx, ls[idx], b.opts.dedupe, batch[idx])
+				done[idx] = true
+				continue
+			}
+			if IsRetryable(err) && attempt < b.opts.retry.MaxAttempts {
+				retryIdxs = append(retryIdxs, idx)
+				retryTs = append(retryTs, ts[i])
+				continue
+			}
+			msg := batch[idx]
+			recordError(span, err)
+			m.fail(topic)
+			ls[idx].Error("处理消息失败",
+				logger.Int("attempts", attempt),
 				logger.Error(err))
+			err = sendDeadLetter(ls[idx], b.opts, msg, err, attempt)
+			if err != nil {
+				dlqErr = err
+			}
+			done[idx] = err == nil
+		}
+		idxs, ts = retryIdxs, retryTs
+		if len(idxs) == 0 {
+			break
 		}
-		for _, msg := range batch {
-			session.MarkMessage(msg, "")
+		m.retry(topic, len(idxs))
+
+		timer := time.NewTimer(b.opts.retry.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			// 重试的时候碰上了 rebalance，剩下的交给下一个消费者
+			timer.Stop()
+			markDone(session, batch, done)
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	markDone(session, batch, done)
+	return dlqErr
+}
+
+// batchFailures 把 fn 返回的错误转成每条消息的错误。
+// 不是 BatchError 的话，就当作整批都失败了
+func batchFailures(err error, n int) map[int]error {
+	if err == nil {
+		return nil
+	}
+	var be BatchError
+	if errors.As(err, &be) {
+		return be
+	}
+	res := make(map[int]error, n)
+	for i := 0; i < n; i++ {
+		res[i] = err
+	}
+	return res
+}
+
+// markDone 只提交前面连续处理完的消息。提交一个 offset 等于提交了它前面的所有消息，
+// 所以碰到第一条没处理完的就要停下来
+func markDone(session sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage, done []bool) {
+	for i, msg := range batch {
+		if !done[i] {
+			return
 		}
+		session.MarkMessage(msg, "")
 	}
 }
@@ -0,0 +1,128 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+func TestBatchHandler_collect(t *testing.T) {
+	testCases := []struct {
+		name      string
+		opts      []Option
+		values    []string
+		closeMsgs bool
+		wantLen   int
+		wantOk    bool
+	}{
+		{
+			name:    "条数够了",
+			opts:    []Option{WithBatchSize(3), WithMaxLinger(time.Minute)},
+			values:  []string{"a", "b", "c", "d"},
+			wantLen: 3,
+			wantOk:  true,
+		},
+		{
+			name:    "字节数够了",
+			opts:    []Option{WithBatchSize(10), WithMaxBytes(4), WithMaxLinger(time.Minute)},
+			values:  []string{"ab", "cd", "ef"},
+			wantLen: 2,
+			wantOk:  true,
+		},
+		{
+			name:    "等够了时间",
+			opts:    []Option{WithBatchSize(10), WithMaxLinger(10 * time.Millisecond)},
+			values:  []string{"a", "b"},
+			wantLen: 2,
+			wantOk:  true,
+		},
+		{
+			name:      "channel 关闭了",
+			opts:      []Option{WithBatchSize(10), WithMaxLinger(time.Minute)},
+			values:    []string{"a"},
+			closeMsgs: true,
+			wantLen:   1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewBatchHandler[string](nil, nil, tc.opts...)
+			msgs := make(chan *sarama.ConsumerMessage, len(tc.values))
+			for _, v := range tc.values {
+				msgs <- &sarama.ConsumerMessage{Value: []byte(v)}
+			}
+			if tc.closeMsgs {
+				close(msgs)
+			}
+			batch, ok := h.collect(context.Background(), msgs)
+			assert.Len(t, batch, tc.wantLen)
+			assert.Equal(t, tc.wantOk, ok)
+		})
+	}
+}
+
+func TestWithBatchSize(t *testing.T) {
+	// 不合法的不生效，不然 collect 里面 make 会 panic
+	for _, n := range []int{0, -1} {
+		assert.Equal(t, defaultOptions().batchSize, newOptions([]Option{WithBatchSize(n)}).batchSize)
+	}
+	assert.Equal(t, 3, newOptions([]Option{WithBatchSize(3)}).batchSize)
+}
+
+func TestBatchHandler_ConsumeClaim(t *testing.T) {
+	cluster := kafkatest.NewCluster()
+	for _, v := range []string{`"a"`, `"b"`, `not json`, `"c"`, `"d"`, `"e"`, `"f"`} {
+		cluster.Publish("t", 0, nil, []byte(v))
+	}
+
+	var batches [][]string
+	failed := map[string]int{}
+	h := NewBatchHandler[string](logger.NewNopLogger(),
+		func(msgs []*sarama.ConsumerMessage, ts []string) error {
+			batches = append(batches, ts)
+			be := BatchError{}
+			for i, v := range ts {
+				switch v {
+				case "c":
+					// 第一次失败，重试成功
+					if failed[v]++; failed[v] == 1 {
+						be[i] = errors.New("mock error")
+					}
+				case "e":
+					be[i] = NonRetryable(errors.New("mock error"))
+				}
+			}
+			if len(be) > 0 {
+				return be
+			}
+			return nil
+		}, WithBatchSize(3), WithMaxLinger(time.Minute),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialInterval: time.Millisecond, Multiplier: 1}),
+		WithDeadLetter(NewDeadLetter(cluster.SyncProducer())))
+	_, err := cluster.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+
+	// 三条一批，反序列化失败的不交给 fn，失败的单独重试
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d", "e"}, {"c"}, {"f"}}, batches)
+	// 反序列化失败的和不能重试的进了死信队列，全部提交
+	dlq := cluster.Messages("t.dlq", 0)
+	require.Len(t, dlq, 2)
+	assert.Equal(t, "not json", string(dlq[0].Value))
+	assert.Equal(t, `"e"`, string(dlq[1].Value))
+	assert.Equal(t, int64(7), cluster.Committed("g", "t", 0))
+}
+
+func TestBatchFailures(t *testing.T) {
+	mockErr := errors.New("mock error")
+	assert.Nil(t, batchFailures(nil, 3))
+	assert.Equal(t, map[int]error{0: mockErr, 1: mockErr}, batchFailures(mockErr, 2))
+	assert.Equal(t, map[int]error{1: mockErr}, batchFailures(BatchError{1: mockErr}, 3))
+}
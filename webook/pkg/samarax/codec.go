@@ -0,0 +1,152 @@
+package samarax
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 消息体的编解码。生产者和消费者用同一个 Codec，一个 topic 的两边才能对得上。
+// Encode 可以顺便返回一些头部，比如 schema 的版本
+type Codec[T any] interface {
+	Encode(t T) ([]byte, []sarama.RecordHeader, error)
+	Decode(value []byte, headers []*sarama.RecordHeader) (T, error)
+}
+
+// JSONCodec 默认的编解码方式
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(t T) ([]byte, []sarama.RecordHeader, error) {
+	val, err := json.Marshal(t)
+	return val, nil, err
+}
+
+func (JSONCodec[T]) Decode(value []byte, headers []*sarama.RecordHeader) (T, error) {
+	var t T
+	err := json.Unmarshal(value, &t)
+	return t, err
+}
+
+// ProtoCodec T 是生成的消息的指针类型，比如 *userv1.UserRegistered
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Encode(t T) ([]byte, []sarama.RecordHeader, error) {
+	val, err := proto.Marshal(t)
+	return val, nil, err
+}
+
+func (ProtoCodec[T]) Decode(value []byte, headers []*sarama.RecordHeader) (T, error) {
+	var zero T
+	// 生成的代码允许在 nil 指针上调用 ProtoReflect，用它来创建一个新的实例
+	t, ok := zero.ProtoReflect().Type().New().Interface().(T)
+	if !ok {
+		return zero, fmt.Errorf("samarax: 无法创建 %T 的实例", zero)
+	}
+	err := proto.Unmarshal(value, t)
+	return t, err
+}
+
+// schema 信息放在这两个头部里面
+const (
+	HeaderSchemaType    = "schema-type"
+	HeaderSchemaVersion = "schema-version"
+)
+
+var ErrSchemaMismatch = errors.New("samarax: 消息的 schema 对不上")
+
+// Upgrader 把 Version 版本的消息体升级到 Version+1
+type Upgrader func(value []byte) ([]byte, error)
+
+// EnvelopeCodec 在内层 Codec 的基础上，把类型名和版本号写进头部。
+// 解码的时候检查类型，老版本的消息用 Upgrader 一级一级升级到当前版本；
+// 比当前版本还新的消息说明消费者落后了，直接报错
+type EnvelopeCodec[T any] struct {
+	TypeName string
+	Version  int
+	Inner    Codec[T]
+	// Upgraders[v] 把 v 版本升级到 v+1
+	Upgraders map[int]Upgrader
+}
+
+func (c EnvelopeCodec[T]) Encode(t T) ([]byte, []sarama.RecordHeader, error) {
+	val, headers, err := c.Inner.Encode(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderSchemaType), Value: []byte(c.TypeName)},
+		sarama.RecordHeader{Key: []byte(HeaderSchemaVersion), Value: []byte(strconv.Itoa(c.Version))},
+	)
+	return val, headers, nil
+}
+
+func (c EnvelopeCodec[T]) Decode(value []byte, headers []*sarama.RecordHeader) (T, error) {
+	var zero T
+	typeName, ok := Header(headers, HeaderSchemaType)
+	if !ok || typeName != c.TypeName {
+		return zero, fmt.Errorf("%w: 期望类型 %s，实际 %q", ErrSchemaMismatch, c.TypeName, typeName)
+	}
+	versionStr, _ := Header(headers, HeaderSchemaVersion)
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return zero, fmt.Errorf("%w: 版本号 %q 不对", ErrSchemaMismatch, versionStr)
+	}
+	if version > c.Version {
+		return zero, fmt.Errorf("%w: 消息版本 %d 比当前版本 %d 新", ErrSchemaMismatch, version, c.Version)
+	}
+	for ; version < c.Version; version++ {
+		upgrade, ok := c.Upgraders[version]
+		if !ok {
+			return zero, fmt.Errorf("%w: 没有从版本 %d 升级的方法", ErrSchemaMismatch, version)
+		}
+		value, err = upgrade(value)
+		if err != nil {
+			return zero, fmt.Errorf("从版本 %d 升级失败: %w", version, err)
+		}
+	}
+	return c.Inner.Decode(value, headers)
+}
+
+// Header 找到第一个名字是 key 的头部
+func Header(headers []*sarama.RecordHeader, key string) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// codecOf 从配置里面拿到 T 对应的 Codec，没有配置就用 JSON
+func codecOf[T any](o options) Codec[T] {
+	if o.codec == nil {
+		return JSONCodec[T]{}
+	}
+	c, ok := o.codec.(Codec[T])
+	if !ok {
+		var t T
+		panic(fmt.Sprintf("samarax: %T 不能用来编解码 %T", o.codec, t))
+	}
+	return c
+}
+
+// NewProducerMessage 用 codec 编码事件，生产者用它保证和消费者用的是同一种格式
+func NewProducerMessage[T any](codec Codec[T], topic string, key []byte, t T) (*sarama.ProducerMessage, error) {
+	val, headers, err := codec.Encode(t)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(val),
+		Headers: headers,
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	return msg, nil
+}
@@ -0,0 +1,76 @@
+package samarax
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type userEvent struct {
+	Uid      int64  `json:"uid"`
+	Nickname string `json:"nickname"`
+}
+
+func toConsumerHeaders(headers []sarama.RecordHeader) []*sarama.RecordHeader {
+	res := make([]*sarama.RecordHeader, 0, len(headers))
+	for i := range headers {
+		res = append(res, &headers[i])
+	}
+	return res
+}
+
+func TestProtoCodec(t *testing.T) {
+	c := ProtoCodec[*wrapperspb.StringValue]{}
+	val, _, err := c.Encode(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	got, err := c.Decode(val, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got.GetValue())
+}
+
+func TestEnvelopeCodec(t *testing.T) {
+	v1 := EnvelopeCodec[userEvent]{TypeName: "UserRegistered", Version: 1, Inner: JSONCodec[userEvent]{}}
+	v2 := EnvelopeCodec[userEvent]{
+		TypeName: "UserRegistered",
+		Version:  2,
+		Inner:    JSONCodec[userEvent]{},
+		Upgraders: map[int]Upgrader{
+			// 版本 1 的字段叫 name，版本 2 改成了 nickname
+			1: func(value []byte) ([]byte, error) {
+				return bytes.Replace(value, []byte(`"name"`), []byte(`"nickname"`), 1), nil
+			},
+		},
+	}
+
+	// 老的生产者发的是版本 1
+	val := []byte(`{"uid":1,"name":"花里"}`)
+	headers := []sarama.RecordHeader{
+		{Key: []byte(HeaderSchemaType), Value: []byte("UserRegistered")},
+		{Key: []byte(HeaderSchemaVersion), Value: []byte("1")},
+	}
+	evt, err := v2.Decode(val, toConsumerHeaders(headers))
+	require.NoError(t, err)
+	assert.Equal(t, userEvent{Uid: 1, Nickname: "花里"}, evt)
+
+	// 新的生产者发的版本 2，老的消费者处理不了
+	val, headers, err = v2.Encode(userEvent{Uid: 2, Nickname: "花里"})
+	require.NoError(t, err)
+	_, err = v1.Decode(val, toConsumerHeaders(headers))
+	assert.ErrorIs(t, err, ErrSchemaMismatch)
+
+	// 类型对不上
+	other := EnvelopeCodec[userEvent]{TypeName: "UserProfileUpdated", Version: 2, Inner: JSONCodec[userEvent]{}}
+	_, err = other.Decode(val, toConsumerHeaders(headers))
+	assert.ErrorIs(t, err, ErrSchemaMismatch)
+}
+
+func TestCodecOf(t *testing.T) {
+	assert.IsType(t, JSONCodec[userEvent]{}, codecOf[userEvent](newOptions(nil)))
+	assert.Panics(t, func() {
+		codecOf[userEvent](newOptions([]Option{WithCodec[string](JSONCodec[string]{})}))
+	})
+}
@@ -0,0 +1,174 @@
+package samarax
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"gochuji/webook/pkg/logger"
+)
+
+// ConcurrentHandler 一个分区的消息分给 N 个 worker 并发处理。
+// 按照消息的 key 哈希到 worker 上，所以同一个 key 的消息还是按顺序处理的；
+// 没有 key 的消息轮流分给各个 worker，不保证顺序。
+// 提交的时候只提交到连续处理完的最大 offset，崩溃之后不会跳过没处理的消息
+type ConcurrentHandler[T any] struct {
+	h       *Handler[T]
+	workers int
+	// 每个 worker 排队的消息数量
+	queueSize int
+}
+
+// NewConcurrentHandler workers 小于等于 0 的时候用 CPU 的个数
+func NewConcurrentHandler[T any](l logger.LoggerV1, fn func(msg *sarama.ConsumerMessage, event T) error,
+	workers int, opts ...Option) *ConcurrentHandler[T] {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &ConcurrentHandler[T]{
+		h:         NewHandler[T](l, fn, opts...),
+		workers:   workers,
+		queueSize: 16,
+	}
+}
+
+func (c *ConcurrentHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *ConcurrentHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+type consumeResult struct {
+	msg *sarama.ConsumerMessage
+	err error
+}
+
+func (c *ConcurrentHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// 有一条消息出错了就通知其它 worker 别重试了
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	results := make(chan consumeResult, c.workers)
+	queues := make([]chan *sarama.ConsumerMessage, c.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, c.queueSize)
+		wg.Add(1)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for msg := range queue {
+				// 已经出错或者 rebalance 了，排队的消息不再处理，也不提交，留给下一次投递
+				if ctx.Err() != nil {
+					continue
+				}
+				err := c.h.consume(ctx, msg)
+				if err != nil {
+					// 马上取消，同一个 worker 后面排队的消息就不会再处理了
+					cancel()
+				}
+				results <- consumeResult{msg: msg, err: err}
+			}
+		}(queues[i])
+	}
+
+	tracker := newOffsetTracker()
+	var firstErr error
+	handle := func(res consumeResult) {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+				cancel()
+			}
+			return
+		}
+		if msg := tracker.complete(res.msg.Offset); msg != nil {
+			session.MarkMessage(msg, "")
+		}
+	}
+
+	msgs := claim.Messages()
+	next := 0
+loop:
+	for firstErr == nil {
+		select {
+		case <-ctx.Done():
+			break loop
+		case res := <-results:
+			handle(res)
+		case msg, ok := <-msgs:
+			if !ok {
+				break loop
+			}
+			tracker.add(msg)
+			queue := queues[c.pick(msg.Key, &next)]
+			// worker 的队列满了的时候，也要继续收结果，不然大家互相等着
+			for dispatched := false; !dispatched; {
+				select {
+				case queue <- msg:
+					dispatched = true
+				case res := <-results:
+					handle(res)
+				}
+			}
+		}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for res := range results {
+		handle(res)
+	}
+	return firstErr
+}
+
+func (c *ConcurrentHandler[T]) pick(key []byte, next *int) int {
+	if len(key) == 0 {
+		idx := *next % c.workers
+		*next++
+		return idx
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(c.workers))
+}
+
+// offsetTracker 记录一个分区里面已经分发出去的消息，
+// 算出来可以提交到哪里：最前面连续处理完的那一段
+type offsetTracker struct {
+	// 按照收到的顺序排列，还没有提交的消息
+	pending []*sarama.ConsumerMessage
+	done    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		done: make(map[int64]bool),
+	}
+}
+
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) {
+	t.pending = append(t.pending, msg)
+}
+
+// complete 标记 offset 处理完了。如果可以提交的位置往前推进了，
+// 返回连续处理完的最后一条消息，否则返回 nil
+func (t *offsetTracker) complete(offset int64) *sarama.ConsumerMessage {
+	t.done[offset] = true
+	var last *sarama.ConsumerMessage
+	for len(t.pending) > 0 && t.done[t.pending[0].Offset] {
+		last = t.pending[0]
+		delete(t.done, last.Offset)
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+	return last
+}
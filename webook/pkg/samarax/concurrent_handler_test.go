@@ -0,0 +1,118 @@
+package samarax
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+	// offset 不一定连续，比如 compact 过的 topic
+	for _, offset := range []int64{10, 11, 13, 14} {
+		tracker.add(&sarama.ConsumerMessage{Offset: offset})
+	}
+
+	// 后面的先处理完，不能提交
+	assert.Nil(t, tracker.complete(13))
+	assert.Nil(t, tracker.complete(11))
+	// 10 处理完了，10、11 都可以提交，13 也是连续的
+	assert.Equal(t, int64(13), tracker.complete(10).Offset)
+	assert.Equal(t, int64(14), tracker.complete(14).Offset)
+	assert.Empty(t, tracker.pending)
+	assert.Empty(t, tracker.done)
+}
+
+func TestConcurrentHandler_pick(t *testing.T) {
+	h := NewConcurrentHandler[string](nil, nil, 4)
+	next := 0
+	// workers 不合法的时候不会除以 0
+	assert.Positive(t, NewConcurrentHandler[string](nil, nil, 0).workers)
+	assert.Positive(t, NewConcurrentHandler[string](nil, nil, -1).workers)
+	// 同一个 key 总是同一个 worker
+	idx := h.pick([]byte("user:1"), &next)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, idx, h.pick([]byte("user:1"), &next))
+	}
+	// 没有 key 的轮流分
+	assert.Equal(t, []int{0, 1, 2, 3, 0}, []int{
+		h.pick(nil, &next), h.pick(nil, &next), h.pick(nil, &next), h.pick(nil, &next), h.pick(nil, &next),
+	})
+}
+
+func TestConcurrentHandler_ConsumeClaim(t *testing.T) {
+	cluster := kafkatest.NewCluster()
+	const keys, perKey = 5, 20
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			cluster.Publish("t", 0, []byte(fmt.Sprintf("key-%d", k)), []byte(fmt.Sprintf(`"%d"`, i)))
+		}
+	}
+
+	var mu sync.Mutex
+	got := make(map[string][]string)
+	h := NewConcurrentHandler[string](logger.NewNopLogger(),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			// 处理得有快有慢，后面的消息可能先处理完
+			time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+			mu.Lock()
+			defer mu.Unlock()
+			got[string(msg.Key)] = append(got[string(msg.Key)], event)
+			return nil
+		}, 3)
+	sess, err := cluster.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+
+	// 同一个 key 按顺序处理
+	require.Len(t, got, keys)
+	for key, events := range got {
+		want := make([]string, 0, perKey)
+		for i := 0; i < perKey; i++ {
+			want = append(want, fmt.Sprint(i))
+		}
+		assert.Equal(t, want, events, key)
+	}
+	// 提交的 offset 只会往前推进，最后全部提交
+	marked := sess.MarkedOffsets()
+	require.NotEmpty(t, marked)
+	for i := 1; i < len(marked); i++ {
+		assert.Greater(t, marked[i], marked[i-1])
+	}
+	assert.Equal(t, int64(keys*perKey), cluster.Committed("g", "t", 0))
+}
+
+func TestConcurrentHandler_StopAfterError(t *testing.T) {
+	cluster := kafkatest.NewCluster()
+	for i := 0; i < 20; i++ {
+		cluster.Publish("t", 0, nil, []byte(fmt.Sprintf(`"%d"`, i)))
+	}
+	// 死信队列也发不出去，ConsumeClaim 只能返回错误
+	cluster.FailSends(1, errors.New("mock dlq error"))
+
+	var handled []string
+	h := NewConcurrentHandler[string](logger.NewNopLogger(),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			handled = append(handled, event)
+			if event == "2" {
+				return NonRetryable(errors.New("mock error"))
+			}
+			return nil
+		}, 1, WithDeadLetter(NewDeadLetter(cluster.SyncProducer())))
+	_, err := cluster.Consume("g", "t", 0, h)
+	require.Error(t, err)
+
+	// 出错之后排队的消息不再处理
+	assert.Equal(t, []string{"0", "1", "2"}, handled)
+	// 只提交出错之前的，出错的那条下次重新投递
+	assert.Equal(t, int64(2), cluster.Committed("g", "t", 0))
+}
@@ -0,0 +1,197 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"gochuji/webook/pkg/logger"
+)
+
+var ErrConsumerStarted = errors.New("samarax: 消费者已经启动了")
+
+// Consumer 管理一个消费者组的生命周期：每次 rebalance 之后重新调用 Consume，
+// 把 sarama 的错误转给日志，记录分到的分区和消息堆积。
+// 想收到 sarama 的错误，创建消费者组的时候要打开 Consumer.Return.Errors
+type Consumer struct {
+	group   sarama.ConsumerGroup
+	groupID string
+	topics  []string
+	handler sarama.ConsumerGroupHandler
+	l       logger.LoggerV1
+	metrics *ConsumerMetrics
+	// Consume 返回错误之后等多久再重新加入消费者组
+	retryInterval time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewConsumer(group sarama.ConsumerGroup, groupID string, topics []string,
+	handler sarama.ConsumerGroupHandler, l logger.LoggerV1, opts ...Option) *Consumer {
+	o := newOptions(opts)
+	return &Consumer{
+		group:         group,
+		groupID:       groupID,
+		topics:        topics,
+		handler:       handler,
+		l:             l,
+		metrics:       o.consumerMetrics,
+		retryInterval: time.Second,
+	}
+}
+
+// Start 在后台开始消费，马上返回
+func (c *Consumer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		return ErrConsumerStarted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	handler := c.handler
+	if c.metrics != nil {
+		handler = &meteredHandler{ConsumerGroupHandler: c.handler, groupID: c.groupID, m: c.metrics}
+	}
+	c.wg.Add(2)
+	go func() {
+		defer c.wg.Done()
+		c.forwardErrors()
+	}()
+	go func() {
+		defer c.wg.Done()
+		c.run(ctx, handler)
+	}()
+	return nil
+}
+
+// Stop 等正在处理的消息处理完，然后关闭消费者组。关闭之后不能再 Start
+func (c *Consumer) Stop() error {
+	c.mu.Lock()
+	cancel := c.cancel
+	c.mu.Unlock()
+	if cancel == nil {
+		return c.group.Close()
+	}
+	cancel()
+	// 先关闭消费者组，Errors 才会关闭，转发错误的 goroutine 才能退出
+	err := c.group.Close()
+	c.wg.Wait()
+	return err
+}
+
+func (c *Consumer) run(ctx context.Context, handler sarama.ConsumerGroupHandler) {
+	for {
+		// rebalance 的时候 Consume 会返回，要重新调用才能拿到新分配的分区
+		err := c.group.Consume(ctx, c.topics, handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+		c.l.Error("消费者组退出，稍后重试",
+			logger.String("group", c.groupID),
+			logger.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+func (c *Consumer) forwardErrors() {
+	for err := range c.group.Errors() {
+		c.l.Error("消费者组出错",
+			logger.String("group", c.groupID),
+			logger.Error(err))
+	}
+}
+
+// ConsumerMetrics 分到的分区数和每个分区堆积的消息数
+type ConsumerMetrics struct {
+	assigned *prometheus.GaugeVec
+	lag      *prometheus.GaugeVec
+}
+
+func NewConsumerMetrics(reg prometheus.Registerer) *ConsumerMetrics {
+	m := &ConsumerMetrics{
+		assigned: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "samarax",
+			Name:      "consumer_assigned_partitions",
+			Help:      "当前分到的分区数",
+		}, []string{"group", "topic"}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "samarax",
+			Name:      "consumer_lag",
+			Help:      "分区里面还没消费的消息数",
+		}, []string{"group", "topic", "partition"}),
+	}
+	reg.MustRegister(m.assigned, m.lag)
+	return m
+}
+
+// WithConsumerMetrics 只有 Consumer 用
+func WithConsumerMetrics(m *ConsumerMetrics) Option {
+	return func(o *options) {
+		o.consumerMetrics = m
+	}
+}
+
+// meteredHandler 在 handler 外面统计分区和堆积
+type meteredHandler struct {
+	sarama.ConsumerGroupHandler
+	groupID string
+	m       *ConsumerMetrics
+}
+
+func (h *meteredHandler) Setup(session sarama.ConsumerGroupSession) error {
+	for topic, partitions := range session.Claims() {
+		h.m.assigned.WithLabelValues(h.groupID, topic).Set(float64(len(partitions)))
+	}
+	return h.ConsumerGroupHandler.Setup(session)
+}
+
+func (h *meteredHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	for topic, partitions := range session.Claims() {
+		h.m.assigned.WithLabelValues(h.groupID, topic).Set(0)
+		// 下次不一定还分到这些分区，不能留着旧的堆积数
+		for _, p := range partitions {
+			h.m.lag.DeleteLabelValues(h.groupID, topic, strconv.Itoa(int(p)))
+		}
+	}
+	return h.ConsumerGroupHandler.Cleanup(session)
+}
+
+func (h *meteredHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	lag := h.m.lag.WithLabelValues(h.groupID, claim.Topic(), strconv.Itoa(int(claim.Partition())))
+	msgs := make(chan *sarama.ConsumerMessage)
+	go func() {
+		defer close(msgs)
+		for msg := range claim.Messages() {
+			lag.Set(float64(claim.HighWaterMarkOffset() - msg.Offset - 1))
+			select {
+			case msgs <- msg:
+			case <-session.Context().Done():
+				return
+			}
+		}
+	}()
+	return h.ConsumerGroupHandler.ConsumeClaim(session, &meteredClaim{ConsumerGroupClaim: claim, msgs: msgs})
+}
+
+type meteredClaim struct {
+	sarama.ConsumerGroupClaim
+	msgs chan *sarama.ConsumerMessage
+}
+
+func (c *meteredClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.msgs
+}
@@ -0,0 +1,128 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+// fakeGroup 每次 Consume 模拟一轮 rebalance：马上返回，或者等到 ctx 结束
+type fakeGroup struct {
+	// 不为 nil 的时候，第一次 Consume 用它模拟分到分区之后的消费
+	onConsume func(handler sarama.ConsumerGroupHandler) error
+	calls     atomic.Int32
+	errs      chan error
+	closed    chan struct{}
+	once      sync.Once
+}
+
+func newFakeGroup() *fakeGroup {
+	return &fakeGroup{errs: make(chan error, 1), closed: make(chan struct{})}
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	call := g.calls.Add(1)
+	if call == 1 && g.onConsume != nil {
+		return g.onConsume(handler)
+	}
+	if call < 3 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-g.closed:
+		return sarama.ErrClosedConsumerGroup
+	}
+}
+
+func (g *fakeGroup) Errors() <-chan error { return g.errs }
+
+func (g *fakeGroup) Close() error {
+	g.once.Do(func() {
+		close(g.closed)
+		close(g.errs)
+	})
+	return nil
+}
+
+func (g *fakeGroup) Pause(partitions map[string][]int32)  {}
+func (g *fakeGroup) Resume(partitions map[string][]int32) {}
+func (g *fakeGroup) PauseAll()                            {}
+func (g *fakeGroup) ResumeAll()                           {}
+
+func TestConsumer_StartStop(t *testing.T) {
+	g := newFakeGroup()
+	core, logs := observer.New(zap.DebugLevel)
+	c := NewConsumer(g, "test", []string{"t"}, NewHandler[string](nil, nil),
+		logger.NewZapLogger(zap.New(core)))
+	require.NoError(t, c.Start())
+	assert.ErrorIs(t, c.Start(), ErrConsumerStarted)
+	g.errs <- errors.New("mock error")
+
+	// 前两次 Consume 模拟 rebalance 返回，要重新调用
+	assert.Eventually(t, func() bool {
+		return g.calls.Load() == 3
+	}, time.Second, time.Millisecond)
+	// sarama 的错误转到了日志里面
+	assert.Eventually(t, func() bool {
+		return logs.FilterMessage("消费者组出错").Len() == 1
+	}, time.Second, time.Millisecond)
+	fields := logs.FilterMessage("消费者组出错").All()[0].ContextMap()
+	assert.Equal(t, "test", fields["group"])
+	assert.Equal(t, "mock error", fields["error"])
+	require.NoError(t, c.Stop())
+}
+
+func TestConsumer_Metrics(t *testing.T) {
+	cluster := kafkatest.NewCluster()
+	for i := 0; i < 3; i++ {
+		cluster.Publish("t", 0, nil, []byte(`"a"`))
+	}
+	m := NewConsumerMetrics(prometheus.NewRegistry())
+	g := newFakeGroup()
+	g.onConsume = func(handler sarama.ConsumerGroupHandler) error {
+		_, err := cluster.Consume("test", "t", 0, handler)
+		return err
+	}
+
+	// 处理的时候看到的分区数和堆积
+	var assigned, lags []float64
+	h := NewHandler[string](logger.NewNopLogger(), func(msg *sarama.ConsumerMessage, event string) error {
+		assigned = append(assigned, testutil.ToFloat64(m.assigned.WithLabelValues("test", "t")))
+		lags = append(lags, testutil.ToFloat64(m.lag.WithLabelValues("test", "t", "0")))
+		return nil
+	})
+	c := NewConsumer(g, "test", []string{"t"}, h, logger.NewNopLogger(), WithConsumerMetrics(m))
+	require.NoError(t, c.Start())
+	assert.Eventually(t, func() bool {
+		return g.calls.Load() == 3
+	}, time.Second, time.Millisecond)
+	require.NoError(t, c.Stop())
+
+	assert.Equal(t, []float64{1, 1, 1}, assigned)
+	// 转发的 goroutine 可能已经多收了一条，堆积数最多比正在处理的这条少一
+	require.Len(t, lags, 3)
+	for i, lag := range lags {
+		want := float64(2 - i)
+		assert.True(t, lag == want || lag == max(want-1, 0), "第 %d 条消息的堆积 %v", i, lag)
+	}
+	// rebalance 之后分区不一定还是自己的，分区数清零，堆积删掉
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.assigned.WithLabelValues("test", "t")))
+	assert.Equal(t, 0, testutil.CollectAndCount(m.lag))
+	assert.Equal(t, int64(3), cluster.Committed("test", "t", 0))
+}
@@ -0,0 +1,64 @@
+package samarax
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrNoDeadLetter 消息处理失败了，但是没有配置死信队列，也没有设置 WithDropFailed
+var ErrNoDeadLetter = errors.New("samarax: 没有配置死信队列")
+
+// 死信消息上面额外带的头部
+const (
+	HeaderDLQError             = "dlq-error"
+	HeaderDLQAttempts          = "dlq-attempts"
+	HeaderDLQOriginalTopic     = "dlq-original-topic"
+	HeaderDLQOriginalPartition = "dlq-original-partition"
+	HeaderDLQOriginalOffset    = "dlq-original-offset"
+)
+
+// DeadLetter 把处理不了的消息转发到 <topic>.dlq，
+// 保留原来的 key 和头部，方便排查之后重新投递
+type DeadLetter struct {
+	producer sarama.SyncProducer
+	suffix   string
+}
+
+func NewDeadLetter(producer sarama.SyncProducer) *DeadLetter {
+	return &DeadLetter{
+		producer: producer,
+		suffix:   ".dlq",
+	}
+}
+
+// Topic 原来的 topic 对应的死信 topic
+func (d *DeadLetter) Topic(topic string) string {
+	return topic + d.suffix
+}
+
+// Send attempts 是一共处理了几次，反序列化失败的时候是 0
+func (d *DeadLetter) Send(msg *sarama.ConsumerMessage, cause error, attempts int) error {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, h := range msg.Headers {
+		headers = append(headers, *h)
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderDLQError), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderDLQAttempts), Value: []byte(strconv.Itoa(attempts))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOriginalTopic), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOriginalPartition), Value: []byte(strconv.FormatInt(int64(msg.Partition), 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOriginalOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+	dlqMsg := &sarama.ProducerMessage{
+		Topic:   d.Topic(msg.Topic),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		dlqMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+	_, _, err := d.producer.SendMessage(dlqMsg)
+	return err
+}
@@ -0,0 +1,156 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"gochuji/webook/pkg/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DedupeStore 记录已经处理过的消息。
+// 处理成功之后才 Mark，所以 Mark 之前崩溃了还是会重复处理一次；
+// 业务数据也在 MySQL 里面的话，用 AtomicDedupe 把两次写放进一个事务
+type DedupeStore interface {
+	Seen(ctx context.Context, id string) (bool, error)
+	Mark(ctx context.Context, id string) error
+}
+
+// MessageID 消息的幂等键：优先用 message-id 头部，
+// 没有的话用 topic/partition/offset，只能识别同一条消息的重复投递
+func MessageID(msg *sarama.ConsumerMessage) string {
+	if id, ok := Header(msg.Headers, HeaderMessageID); ok && id != "" {
+		return id
+	}
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// WithDedupe 跳过处理过的消息，Handler、ConcurrentHandler 和 BatchHandler 都支持
+func WithDedupe(store DedupeStore) Option {
+	return func(o *options) {
+		o.dedupe = store
+	}
+}
+
+// seen 查询失败的时候当作没处理过，宁可重复也不能丢
+func seen(ctx context.Context, l logger.LoggerV1, store DedupeStore, msg *sarama.ConsumerMessage) bool {
+	if store == nil {
+		return false
+	}
+	ok, err := store.Seen(ctx, MessageID(msg))
+	if err != nil {
+		l.Warn("查询消息是否处理过失败", logger.Error(err))
+		return false
+	}
+	return ok
+}
+
+// markSeen 记录失败了也不影响提交，最多下次重复处理一次
+func markSeen(ctx context.Context, l logger.LoggerV1, store DedupeStore, msg *sarama.ConsumerMessage) {
+	if store == nil {
+		return
+	}
+	err := store.Mark(ctx, MessageID(msg))
+	if err != nil {
+		l.Warn("记录消息已处理失败", logger.Error(err))
+	}
+}
+
+// RedisDedupeStore 处理过的消息 id 在 Redis 里面保留 ttl，
+// ttl 要比消息可能重复投递的时间窗口长
+type RedisDedupeStore struct {
+	client redis.Cmdable
+	prefix string
+	ttl    time.Duration
+}
+
+func NewRedisDedupeStore(client redis.Cmdable, prefix string, ttl time.Duration) *RedisDedupeStore {
+	return &RedisDedupeStore{client: client, prefix: prefix, ttl: ttl}
+}
+
+func (s *RedisDedupeStore) Seen(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.key(id)).Result()
+	return n > 0, err
+}
+
+func (s *RedisDedupeStore) Mark(ctx context.Context, id string) error {
+	return s.client.Set(ctx, s.key(id), 1, s.ttl).Err()
+}
+
+func (s *RedisDedupeStore) key(id string) string {
+	return fmt.Sprintf("%s:dedupe:%s", s.prefix, id)
+}
+
+// ConsumedMessage 处理过的消息。不同的消费者组各自处理一遍，所以主键带上组名
+type ConsumedMessage struct {
+	ConsumerGroup string `gorm:"primaryKey;size:128"`
+	Id            string `gorm:"primaryKey;size:255"`
+	Ctime         int64  `gorm:"index"`
+}
+
+func (ConsumedMessage) TableName() string {
+	return "consumed_messages"
+}
+
+// GormDedupeStore 把处理过的消息 id 存在 MySQL 里面，多个消费者组可以共用一张表
+type GormDedupeStore struct {
+	db    *gorm.DB
+	group string
+}
+
+// NewGormDedupeStore group 是消费者组的 id，只看这个组有没有处理过
+func NewGormDedupeStore(db *gorm.DB, group string) *GormDedupeStore {
+	return &GormDedupeStore{db: db, group: group}
+}
+
+func (s *GormDedupeStore) InitTable() error {
+	return s.db.AutoMigrate(&ConsumedMessage{})
+}
+
+func (s *GormDedupeStore) Seen(ctx context.Context, id string) (bool, error) {
+	var m ConsumedMessage
+	err := s.db.WithContext(ctx).Where("consumer_group = ? AND id = ?", s.group, id).First(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *GormDedupeStore) Mark(ctx context.Context, id string) error {
+	_, err := s.markTx(s.db.WithContext(ctx), id)
+	return err
+}
+
+// Cleanup 删掉 before 之前的记录，由定时任务调用
+func (s *GormDedupeStore) Cleanup(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).Where("consumer_group = ? AND ctime < ?", s.group, before.UnixMilli()).
+		Delete(&ConsumedMessage{}).Error
+}
+
+// markTx 返回 false 代表已经有这条记录了
+func (s *GormDedupeStore) markTx(tx *gorm.DB, id string) (bool, error) {
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&ConsumedMessage{ConsumerGroup: s.group, Id: id, Ctime: time.Now().UnixMilli()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// AtomicDedupe 在同一个事务里面记录消息 id 和执行 fn，fn 要用传进去的 tx 写业务数据。
+// 两个消费者同时拿到同一条消息的时候，后插入的会等前一个事务提交，然后发现已经处理过了。
+// 用了它就不要再配置 WithDedupe
+func AtomicDedupe[T any](s *GormDedupeStore, fn func(tx *gorm.DB, msg *sarama.ConsumerMessage, event T) error) func(msg *sarama.ConsumerMessage, event T) error {
+	return func(msg *sarama.ConsumerMessage, event T) error {
+		// 带上处理这条消息的 context，rebalance 的时候能取消，链路也能接上
+		return s.db.WithContext(MessageContext(msg)).Transaction(func(tx *gorm.DB) error {
+			ok, err := s.markTx(tx, MessageID(msg))
+			if err != nil || !ok {
+				return err
+			}
+			return fn(tx, msg, event)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package samarax
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"gochuji/webook/pkg/logger"
+)
+
+type memDedupeStore map[string]bool
+
+func (s memDedupeStore) Seen(ctx context.Context, id string) (bool, error) {
+	return s[id], nil
+}
+
+func (s memDedupeStore) Mark(ctx context.Context, id string) error {
+	s[id] = true
+	return nil
+}
+
+func TestMessageID(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Topic: "user_events", Partition: 1, Offset: 42}
+	assert.Equal(t, "user_events/1/42", MessageID(msg))
+	msg.Headers = []*sarama.RecordHeader{{Key: []byte(HeaderMessageID), Value: []byte("abc")}}
+	assert.Equal(t, "abc", MessageID(msg))
+}
+
+func TestHandler_dedupe(t *testing.T) {
+	store := memDedupeStore{}
+	calls := 0
+	h := NewHandler[string](logger.NewZapLogger(zap.NewNop()),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			calls++
+			return nil
+		}, WithDedupe(store))
+	msg := &sarama.ConsumerMessage{
+		Value:   []byte(`"hello"`),
+		Headers: []*sarama.RecordHeader{{Key: []byte(HeaderMessageID), Value: []byte("abc")}},
+	}
+	// 重新投递的消息 offset 不一样，但是 message-id 一样
+	for offset := int64(0); offset < 3; offset++ {
+		msg.Offset = offset
+		require.NoError(t, h.consume(context.Background(), msg))
+	}
+	assert.Equal(t, 1, calls)
+	assert.True(t, store["abc"])
+}
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{SkipDefaultTransaction: true})
+	require.NoError(t, err)
+	return db, mock
+}
+
+func TestGormDedupeStore_Group(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := NewGormDedupeStore(db, "g1")
+	// 只查自己这个组的记录
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `consumed_messages` WHERE consumer_group = ? AND id = ?")).
+		WithArgs("g1", "abc", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"consumer_group", "id", "ctime"}))
+	seen, err := s.Seen(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `consumed_messages` (`consumer_group`,`id`,`ctime`)")).
+		WithArgs("g1", "abc", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.Mark(context.Background(), "abc"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAtomicDedupe_Context(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := NewGormDedupeStore(db, "g1")
+	msg := &sarama.ConsumerMessage{Topic: "t", Offset: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	messageContext(ctx, logger.NewNopLogger(), msg)
+	defer forgetMessages(msg)
+
+	called := false
+	fn := AtomicDedupe[string](s, func(tx *gorm.DB, msg *sarama.ConsumerMessage, event string) error {
+		called = true
+		return nil
+	})
+	// 处理的 context 取消了，事务都不会开始
+	cancel()
+	assert.ErrorIs(t, fn(msg, "v"), context.Canceled)
+	assert.False(t, called)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,18 +1,24 @@
 package samarax
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+
 	"github.com/IBM/sarama"
 	"gochuji/webook/pkg/logger"
 )
 
 type Handler[T any] struct {
-	l  logger.LoggerV1
-	fn func(msg *sarama.ConsumerMessage, event T) error
+	l     logger.LoggerV1
+	fn    func(msg *sarama.ConsumerMessage, event T) error
+	opts  options
+	codec Codec[T]
 }
 
-func NewHandler[T any](l logger.LoggerV1, fn func(msg *sarama.ConsumerMessage, event T) error) *Handler[T] {
-	return &Handler[T]{l: l, fn: fn}
+func NewHandler[T any](l logger.LoggerV1, fn func(msg *sarama.ConsumerMessage, event T) error, opts ...Option) *Handler[T] {
+	o := newOptions(opts)
+	return &Handler[T]{l: l, fn: fn, opts: o, codec: codecOf[T](o)}
 }
 
 func (h *Handler[T]) Setup(session sarama.ConsumerGroupSession) error {
@@ -26,26 +32,74 @@ func (h *Handler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 func (h *Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	msgs := claim.Messages()
 	for msg := range msgs {
-		// 在这里调用业务处理逻辑
-		var t T
-		err := json.Unmarshal(msg.Value, &t)
-		if err != nil {
-			// 你也可以在这里引入重试的逻辑
-			h.l.Error("反序列消息体失败",
-				logger.String("topic", msg.Topic),
-				logger.Int32("partition", msg.Partition),
-				logger.Int64("offset", msg.Offset),
-				logger.Error(err))
-		}
-		err = h.fn(msg, t)
+		err := h.consume(session.Context(), msg)
 		if err != nil {
-			h.l.Error("处理消息失败",
-				logger.String("topic", msg.Topic),
-				logger.Int32("partition", msg.Partition),
-				logger.Int64("offset", msg.Offset),
-				logger.Error(err))
+			// 没处理完，也没能进死信队列，不能提交。
+			// 退出之后重新分配分区，这条消息会重新投递
+			return err
 		}
 		session.MarkMessage(msg, "")
 	}
 	return nil
 }
+
+// consume 返回 nil 代表这条消息可以提交了：要么处理成功，要么已经进了死信队列
+func (h *Handler[T]) consume(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	ctx, span := startConsumerSpan(ctx, h.opts, msg)
+	ctx, l := messageContext(ctx, h.l, msg)
+	defer func() {
+		forgetMessages(msg)
+		span.End()
+	}()
+	m := h.opts.handlerMetrics
+	m.consume(msg.Topic, 1)
+	t, err := h.codec.Decode(msg.Value, msg.Headers)
+	if err != nil {
+		recordError(span, err)
+		m.fail(msg.Topic)
+		// 反序列化失败重试也没用，直接进死信队列
+		l.Error("反序列消息体失败", logger.Error(err))
+		return sendDeadLetter(l, h.opts, msg, err, 0)
+	}
+	if seen(ctx, l, h.opts.dedupe, msg) {
+		return nil
+	}
+	attempts, err := h.opts.retry.Do(ctx, func() error {
+		return h.fn(msg, t)
+	})
+	m.retry(msg.Topic, attempts-1)
+	if err == nil {
+		markSeen(ctx, l, h.opts.dedupe, msg)
+		return nil
+	}
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// 重试的时候碰上了 rebalance，交给下一个消费者
+		return err
+	}
+	recordError(span, err)
+	m.fail(msg.Topic)
+	l.Error("处理消息失败",
+		logger.Int("attempts", attempts),
+		logger.Error(err))
+	return sendDeadLetter(l, h.opts, msg, err, attempts)
+}
+
+// sendDeadLetter 没有配置死信队列的时候，设置了 WithDropFailed 才丢掉，
+// 否则返回 ErrNoDeadLetter，这条消息不会被提交。
+// l 要带上消息的位置，参考 messageContext
+func sendDeadLetter(l logger.LoggerV1, o options, msg *sarama.ConsumerMessage, cause error, attempts int) error {
+	dlq := o.dlq
+	if dlq == nil {
+		if o.dropFailed {
+			l.Warn("没有配置死信队列，丢弃消息")
+			return nil
+		}
+		l.Error("没有配置死信队列，消息不提交")
+		return fmt.Errorf("%w: %w", ErrNoDeadLetter, cause)
+	}
+	err := dlq.Send(msg, cause, attempts)
+	if err != nil {
+		l.Error("发送到死信队列失败", logger.Error(err))
+	}
+	return err
+}
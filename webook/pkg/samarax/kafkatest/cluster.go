@@ -0,0 +1,179 @@
+// Package kafkatest 在内存里面模拟 Kafka，用来离线测试 samarax 的 Handler、BatchHandler 和 Producer
+package kafkatest
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// Cluster 内存里面的 topic 和消费者组提交的 offset
+type Cluster struct {
+	mu     sync.Mutex
+	topics map[string][][]*sarama.ConsumerMessage
+	// group -> 分区 -> 下一条要消费的 offset
+	committed map[string]map[topicPartition]int64
+	// 接下来的几次发送返回这些错误
+	sendErrs []error
+}
+
+func NewCluster() *Cluster {
+	return &Cluster{
+		topics:    make(map[string][][]*sarama.ConsumerMessage),
+		committed: make(map[string]map[topicPartition]int64),
+	}
+}
+
+// CreateTopic 没有创建过的 topic 第一次写的时候自动创建，只有一个分区
+func (c *Cluster) CreateTopic(topic string, partitions int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics[topic] = make([][]*sarama.ConsumerMessage, partitions)
+}
+
+// Publish 直接写到指定的分区，返回 offset。分区不够的时候自动增加
+func (c *Cluster) Publish(topic string, partition int32, key, value []byte, headers ...sarama.RecordHeader) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.append(topic, partition, key, value, headers)
+}
+
+func (c *Cluster) append(topic string, partition int32, key, value []byte, headers []sarama.RecordHeader) int64 {
+	if _, ok := c.topics[topic]; !ok {
+		c.topics[topic] = make([][]*sarama.ConsumerMessage, 1)
+	}
+	// 写到还不存在的分区的时候，把分区加到够为止
+	if n := int(partition) + 1; n > len(c.topics[topic]) {
+		c.topics[topic] = append(c.topics[topic], make([][]*sarama.ConsumerMessage, n-len(c.topics[topic]))...)
+	}
+	msgs := c.topics[topic][partition]
+	hs := make([]*sarama.RecordHeader, 0, len(headers))
+	for i := range headers {
+		h := headers[i]
+		hs = append(hs, &h)
+	}
+	msg := &sarama.ConsumerMessage{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    int64(len(msgs)),
+		Key:       key,
+		Value:     value,
+		Headers:   hs,
+		Timestamp: time.Now(),
+	}
+	c.topics[topic][partition] = append(msgs, msg)
+	return msg.Offset
+}
+
+// Messages 分区里面所有的消息
+func (c *Cluster) Messages(topic string, partition int32) []*sarama.ConsumerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parts := c.topics[topic]
+	if int(partition) >= len(parts) {
+		return nil
+	}
+	return append([]*sarama.ConsumerMessage(nil), parts[partition]...)
+}
+
+// FailSends 接下来的 n 次发送都返回 err
+func (c *Cluster) FailSends(n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < n; i++ {
+		c.sendErrs = append(c.sendErrs, err)
+	}
+}
+
+// Send 实现了 samarax.Sender，按照 key 的哈希选分区，没有 key 的写到 0 号分区
+func (c *Cluster) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	_, _, err := c.send(msg)
+	return err
+}
+
+func (c *Cluster) send(msg *sarama.ProducerMessage) (int32, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sendErrs) > 0 {
+		err := c.sendErrs[0]
+		c.sendErrs = c.sendErrs[1:]
+		return 0, 0, err
+	}
+	var key, value []byte
+	var err error
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return 0, 0, err
+		}
+	}
+	var partition int32
+	if n := len(c.topics[msg.Topic]); n > 1 && key != nil {
+		h := fnv.New32a()
+		h.Write(key)
+		partition = int32(h.Sum32() % uint32(n))
+	}
+	offset := c.append(msg.Topic, partition, key, value, msg.Headers)
+	msg.Partition, msg.Offset = partition, offset
+	return partition, offset, nil
+}
+
+// SyncProducer 写到这个 Cluster 的 sarama.SyncProducer，可以用来创建 samarax.DeadLetter。
+// 只实现了 SendMessage、SendMessages 和 Close，事务相关的方法会 panic
+func (c *Cluster) SyncProducer() sarama.SyncProducer {
+	return &syncProducer{c: c}
+}
+
+type syncProducer struct {
+	sarama.SyncProducer
+	c *Cluster
+}
+
+func (p *syncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return p.c.send(msg)
+}
+
+func (p *syncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.c.send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *syncProducer) Close() error {
+	return nil
+}
+
+// Committed 消费者组在这个分区提交的位置，也就是下一条要消费的 offset
+func (c *Cluster) Committed(group, topic string, partition int32) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.committed[group][topicPartition{topic, partition}]
+}
+
+func (c *Cluster) commit(group string, offsets map[topicPartition]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.committed[group] == nil {
+		c.committed[group] = make(map[topicPartition]int64)
+	}
+	for tp, offset := range offsets {
+		if offset > c.committed[group][tp] {
+			c.committed[group][tp] = offset
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package kafkatest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+var fastRetry = samarax.WithRetryPolicy(samarax.RetryPolicy{
+	MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 1,
+})
+
+func publish(c *kafkatest.Cluster, values ...string) {
+	for _, v := range values {
+		c.Publish("t", 0, nil, []byte(`"`+v+`"`))
+	}
+}
+
+func TestHandler_DeadLetter(t *testing.T) {
+	c := kafkatest.NewCluster()
+	publish(c, "a", "bad", "c")
+	var got []string
+	h := samarax.NewHandler[string](logger.NewZapLogger(zap.NewNop()),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			if event == "bad" {
+				return errors.New("mock error")
+			}
+			got = append(got, event)
+			return nil
+		}, fastRetry, samarax.WithDeadLetter(samarax.NewDeadLetter(c.SyncProducer())))
+
+	sess, err := c.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, got)
+	assert.Equal(t, []int64{0, 1, 2}, sess.MarkedOffsets())
+	assert.Equal(t, int64(3), c.Committed("g", "t", 0))
+	dlq := c.Messages("t.dlq", 0)
+	require.Len(t, dlq, 1)
+	assert.Equal(t, []byte(`"bad"`), dlq[0].Value)
+}
+
+func TestHandler_Rebalance(t *testing.T) {
+	c := kafkatest.NewCluster()
+	publish(c, "a", "b", "c", "d")
+	var got []string
+	h := samarax.NewHandler[string](logger.NewZapLogger(zap.NewNop()),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			got = append(got, event)
+			return nil
+		})
+
+	_, err := c.Consume("g", "t", 0, h, kafkatest.RebalanceAfter(2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), c.Committed("g", "t", 0))
+	// 重新分配之后从提交的位置继续
+	sess, err := c.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, sess.MarkedOffsets())
+	assert.Equal(t, []string{"a", "b", "c", "d"}, got)
+}
+
+func TestBatchHandler_DeadLetterFailed(t *testing.T) {
+	c := kafkatest.NewCluster()
+	publish(c, "a", "b", "bad", "d")
+	calls := 0
+	h := samarax.NewBatchHandler[string](logger.NewZapLogger(zap.NewNop()),
+		func(msgs []*sarama.ConsumerMessage, ts []string) error {
+			calls++
+			be := samarax.BatchError{}
+			for i, v := range ts {
+				if v == "bad" {
+					be[i] = samarax.NonRetryable(errors.New("mock error"))
+				}
+			}
+			if len(be) == 0 {
+				return nil
+			}
+			return be
+		}, fastRetry, samarax.WithBatchSize(4), samarax.WithMaxLinger(time.Second),
+		samarax.WithDeadLetter(samarax.NewDeadLetter(c.SyncProducer())))
+	// 死信队列也写不进去，这条消息和它后面的都不能提交
+	c.FailSends(1, errors.New("broker down"))
+
+	sess, err := c.Consume("g", "t", 0, h)
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []int64{0, 1}, sess.MarkedOffsets())
+	assert.Equal(t, int64(2), c.Committed("g", "t", 0))
+	assert.Empty(t, c.Messages("t.dlq", 0))
+}
+
+func TestProducer(t *testing.T) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("t", 4)
+	p := samarax.NewProducer[string](c, "t",
+		samarax.WithKeyFunc(func(s string) []byte { return []byte(s) }))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Produce(t.Context(), "same-key"))
+	}
+	total := 0
+	for part := int32(0); part < 4; part++ {
+		msgs := c.Messages("t", part)
+		// 同一个 key 都在一个分区里面
+		assert.True(t, len(msgs) == 0 || len(msgs) == 3)
+		total += len(msgs)
+	}
+	assert.Equal(t, 3, total)
+}
+
+func TestCluster_PublishGrowsPartitions(t *testing.T) {
+	c := kafkatest.NewCluster()
+	// 自动创建的 topic 只有一个分区，写到 1 号分区不能 panic
+	assert.Equal(t, int64(0), c.Publish("t", 1, nil, []byte("a")))
+	assert.Equal(t, int64(1), c.Publish("t", 1, nil, []byte("b")))
+	assert.Empty(t, c.Messages("t", 0))
+	assert.Len(t, c.Messages("t", 1), 2)
+
+	c.CreateTopic("u", 2)
+	assert.Equal(t, int64(0), c.Publish("u", 3, nil, []byte("a")))
+	assert.Len(t, c.Messages("u", 3), 1)
+	assert.Empty(t, c.Messages("u", 2))
+}
@@ -0,0 +1,169 @@
+package kafkatest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+type consumeOptions struct {
+	rebalanceAfter int
+}
+
+type ConsumeOption func(o *consumeOptions)
+
+// RebalanceAfter 投递了 n 条消息之后模拟一次 rebalance：
+// session 的 context 被取消，分区的 channel 被关闭
+func RebalanceAfter(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.rebalanceAfter = n
+	}
+}
+
+// Consume 模拟消费者组的一个成员分到了一个分区：从提交的位置开始，把分区里面现有的消息都投递给 handler，
+// 投递完了就关闭 channel。handler 返回之后提交标记过的 offset，下一次 Consume 从那里继续，
+// 这样就能看到没提交的消息被重新投递
+func (c *Cluster) Consume(group, topic string, partition int32, handler sarama.ConsumerGroupHandler, opts ...ConsumeOption) (*Session, error) {
+	var o consumeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &Session{
+		ctx:    ctx,
+		claims: map[string][]int32{topic: {partition}},
+		marked: make(map[topicPartition]int64),
+	}
+	msgs := c.Messages(topic, partition)
+	start := c.Committed(group, topic, partition)
+	if start < int64(len(msgs)) {
+		msgs = msgs[start:]
+	} else {
+		msgs = nil
+	}
+	claim := &Claim{
+		topic:     topic,
+		partition: partition,
+		initial:   start,
+		hwm:       start + int64(len(msgs)),
+		msgs:      make(chan *sarama.ConsumerMessage),
+	}
+	go func() {
+		defer close(claim.msgs)
+		for i, msg := range msgs {
+			if o.rebalanceAfter > 0 && i == o.rebalanceAfter {
+				cancel()
+				return
+			}
+			select {
+			case claim.msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err := handler.Setup(sess)
+	if err == nil {
+		err = handler.ConsumeClaim(sess, claim)
+		if cerr := handler.Cleanup(sess); err == nil {
+			err = cerr
+		}
+	}
+	// 不管成功失败，标记过的都会提交
+	sess.mu.Lock()
+	c.commit(group, sess.marked)
+	sess.mu.Unlock()
+	return sess, err
+}
+
+// Session 实现了 sarama.ConsumerGroupSession，记录标记过的消息
+type Session struct {
+	ctx    context.Context
+	claims map[string][]int32
+
+	mu sync.Mutex
+	// 分区 -> 下一条要消费的 offset
+	marked map[topicPartition]int64
+	// 按照标记的顺序，被标记的消息的 offset
+	markedMsgs []int64
+}
+
+func (s *Session) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *Session) MemberID() string {
+	return "kafkatest"
+}
+
+func (s *Session) GenerationID() int32 {
+	return 1
+}
+
+func (s *Session) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tp := topicPartition{topic, partition}
+	// 和 sarama 一样，只能往前推进
+	if offset > s.marked[tp] {
+		s.marked[tp] = offset
+	}
+}
+
+func (s *Session) Commit() {}
+
+func (s *Session) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked[topicPartition{topic, partition}] = offset
+}
+
+func (s *Session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mu.Lock()
+	s.markedMsgs = append(s.markedMsgs, msg.Offset)
+	s.mu.Unlock()
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// MarkedOffsets 按照标记的顺序返回被标记的消息的 offset
+func (s *Session) MarkedOffsets() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.markedMsgs...)
+}
+
+// Claim 实现了 sarama.ConsumerGroupClaim
+type Claim struct {
+	topic     string
+	partition int32
+	initial   int64
+	hwm       int64
+	msgs      chan *sarama.ConsumerMessage
+}
+
+func (c *Claim) Topic() string {
+	return c.topic
+}
+
+func (c *Claim) Partition() int32 {
+	return c.partition
+}
+
+func (c *Claim) InitialOffset() int64 {
+	return c.initial
+}
+
+func (c *Claim) HighWaterMarkOffset() int64 {
+	return c.hwm
+}
+
+func (c *Claim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.msgs
+}
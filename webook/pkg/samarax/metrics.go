@@ -0,0 +1,73 @@
+package samarax
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HandlerMetrics Handler、ConcurrentHandler 和 BatchHandler 处理消息的统计。
+// 方法在 nil 上调用什么也不做，没有配置的时候不用判断
+type HandlerMetrics struct {
+	consumed  *prometheus.CounterVec
+	failed    *prometheus.CounterVec
+	retried   *prometheus.CounterVec
+	batchSize *prometheus.HistogramVec
+}
+
+func NewHandlerMetrics(reg prometheus.Registerer) *HandlerMetrics {
+	m := &HandlerMetrics{
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "samarax",
+			Name:      "consumed_total",
+			Help:      "收到的消息数",
+		}, []string{"topic"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "samarax",
+			Name:      "failed_total",
+			Help:      "重试之后还是处理失败，或者反序列化失败的消息数",
+		}, []string{"topic"}),
+		retried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "samarax",
+			Name:      "retried_total",
+			Help:      "重试的次数",
+		}, []string{"topic"}),
+		batchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "samarax",
+			Name:      "batch_size",
+			Help:      "BatchHandler 每一批的消息数",
+			Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500},
+		}, []string{"topic"}),
+	}
+	reg.MustRegister(m.consumed, m.failed, m.retried, m.batchSize)
+	return m
+}
+
+// WithHandlerMetrics 只有 Handler、ConcurrentHandler 和 BatchHandler 用
+func WithHandlerMetrics(m *HandlerMetrics) Option {
+	return func(o *options) {
+		o.handlerMetrics = m
+	}
+}
+
+func (m *HandlerMetrics) consume(topic string, n int) {
+	if m != nil {
+		m.consumed.WithLabelValues(topic).Add(float64(n))
+	}
+}
+
+func (m *HandlerMetrics) fail(topic string) {
+	if m != nil {
+		m.failed.WithLabelValues(topic).Inc()
+	}
+}
+
+func (m *HandlerMetrics) retry(topic string, n int) {
+	if m != nil && n > 0 {
+		m.retried.WithLabelValues(topic).Add(float64(n))
+	}
+}
+
+func (m *HandlerMetrics) batch(topic string, n int) {
+	if m != nil {
+		m.batchSize.WithLabelValues(topic).Observe(float64(n))
+	}
+}
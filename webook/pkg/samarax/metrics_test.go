@@ -0,0 +1,41 @@
+package samarax
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+func TestHandlerMetrics(t *testing.T) {
+	m := NewHandlerMetrics(prometheus.NewRegistry())
+	cluster := kafkatest.NewCluster()
+	cluster.Publish("t", 0, nil, []byte(`"ok"`))
+	cluster.Publish("t", 0, nil, []byte(`"bad"`))
+	cluster.Publish("t", 0, nil, []byte(`not json`))
+
+	h := NewHandler[string](logger.NewZapLogger(zap.NewNop()),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			if event == "bad" {
+				return errors.New("mock error")
+			}
+			return nil
+		}, WithHandlerMetrics(m), WithDropFailed(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 1}))
+	_, err := cluster.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.consumed.WithLabelValues("t")))
+	// bad 处理失败，not json 反序列化失败
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.failed.WithLabelValues("t")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.retried.WithLabelValues("t")))
+}
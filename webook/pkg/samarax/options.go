@@ -0,0 +1,138 @@
+package samarax
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	retry RetryPolicy
+	// 为 nil 的时候，失败的消息不提交，除非设置了 dropFailed
+	dlq *DeadLetter
+	// 没有死信队列的时候，失败的消息记个日志就丢掉
+	dropFailed bool
+	// Codec[T]，因为 Option 不是泛型的，所以存成 any，在构造 handler 的时候检查类型
+	codec any
+	// 为 nil 的时候不去重
+	dedupe DedupeStore
+	// 为 nil 的时候用 otel 全局的
+	tracerProvider trace.TracerProvider
+	// 为 nil 的时候不统计
+	handlerMetrics *HandlerMetrics
+
+	// 下面几个只有 Producer 用
+	// func(T) []byte，和 codec 一样存成 any
+	keyFunc         any
+	eventType       string
+	traceID         func(ctx context.Context) string
+	headerInjectors []func(ctx context.Context) []sarama.RecordHeader
+
+	// 只有 Consumer 用
+	consumerMetrics *ConsumerMetrics
+
+	// 下面几个只有 BatchHandler 用
+	batchSize int
+	maxLinger time.Duration
+	// 小于等于 0 代表不限制
+	maxBytes int
+}
+
+func defaultOptions() options {
+	return options{
+		retry:     DefaultRetryPolicy(),
+		batchSize: 10,
+		maxLinger: time.Second,
+	}
+}
+
+// Option Handler、BatchHandler、Producer 和 Consumer 共用的配置，用不到的会被忽略
+type Option func(o *options)
+
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+func WithDeadLetter(d *DeadLetter) Option {
+	return func(o *options) {
+		o.dlq = d
+	}
+}
+
+// WithDropFailed 没有配置死信队列的时候，处理失败的消息记录日志之后直接丢掉。
+// 默认不丢，返回错误，消息不提交，重新分配分区之后再投递
+func WithDropFailed() Option {
+	return func(o *options) {
+		o.dropFailed = true
+	}
+}
+
+// WithCodec 指定消息体的编解码方式，默认是 JSON
+func WithCodec[T any](c Codec[T]) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithKeyFunc 从事件里面提取消息的 key，同一个 key 会进同一个分区
+func WithKeyFunc[T any](fn func(T) []byte) Option {
+	return func(o *options) {
+		o.keyFunc = fn
+	}
+}
+
+// WithEventType 写到 event-type 头部里面
+func WithEventType(eventType string) Option {
+	return func(o *options) {
+		o.eventType = eventType
+	}
+}
+
+// WithTraceID 从 ctx 里面拿到 trace id，写到 trace-id 头部里面
+func WithTraceID(fn func(ctx context.Context) string) Option {
+	return func(o *options) {
+		o.traceID = fn
+	}
+}
+
+// WithHeaders 发送之前额外加一些头部
+func WithHeaders(fn func(ctx context.Context) []sarama.RecordHeader) Option {
+	return func(o *options) {
+		o.headerInjectors = append(o.headerInjectors, fn)
+	}
+}
+
+// WithBatchSize 一批最多多少条消息，小于 1 的不生效，还是用默认的 10
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.batchSize = n
+		}
+	}
+}
+
+// WithMaxLinger 一批的第一条消息到了之后，最多再等多久
+func WithMaxLinger(d time.Duration) Option {
+	return func(o *options) {
+		o.maxLinger = d
+	}
+}
+
+// WithMaxBytes 一批消息的 value 加起来超过多少字节就不再等了
+func WithMaxBytes(n int) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,98 @@
+package samarax
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// 生产者默认带上的头部
+const (
+	HeaderTraceID    = "trace-id"
+	HeaderEventType  = "event-type"
+	HeaderProducedAt = "produced-at"
+	// HeaderMessageID 幂等键，同一条消息重复投递的时候不变
+	HeaderMessageID = "message-id"
+)
+
+// Producer 带类型的生产者，和 Handler[T] 共用 Option，
+// 用同一个 WithCodec 就能保证两边的格式一致
+type Producer[T any] struct {
+	sender Sender
+	topic  string
+	codec  Codec[T]
+	key    func(T) []byte
+	opts   options
+}
+
+func NewProducer[T any](sender Sender, topic string, opts ...Option) *Producer[T] {
+	o := newOptions(opts)
+	p := &Producer[T]{
+		sender: sender,
+		topic:  topic,
+		codec:  codecOf[T](o),
+		opts:   o,
+	}
+	if o.keyFunc != nil {
+		key, ok := o.keyFunc.(func(T) []byte)
+		if !ok {
+			var t T
+			panic(fmt.Sprintf("samarax: %T 不能用来提取 %T 的 key", o.keyFunc, t))
+		}
+		p.key = key
+	}
+	return p
+}
+
+func (p *Producer[T]) Produce(ctx context.Context, evt T) error {
+	ctx, span := startProducerSpan(ctx, p.opts, p.topic)
+	defer span.End()
+	err := p.produce(ctx, evt)
+	if err != nil {
+		recordError(span, err)
+	}
+	return err
+}
+
+func (p *Producer[T]) produce(ctx context.Context, evt T) error {
+	var key []byte
+	if p.key != nil {
+		key = p.key(evt)
+	}
+	msg, err := NewProducerMessage(p.codec, p.topic, key, evt)
+	if err != nil {
+		return err
+	}
+	msg.Headers = append(msg.Headers, p.headers(ctx)...)
+	Inject(ctx, &msg.Headers)
+	return p.sender.Send(ctx, msg)
+}
+
+// Healthy sender 没有健康度统计的话总是健康的
+func (p *Producer[T]) Healthy() bool {
+	if hs, ok := p.sender.(interface{ Healthy() bool }); ok {
+		return hs.Healthy()
+	}
+	return true
+}
+
+func (p *Producer[T]) headers(ctx context.Context) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{
+		{Key: []byte(HeaderProducedAt), Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	}
+	if p.opts.eventType != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderEventType), Value: []byte(p.opts.eventType)})
+	}
+	if p.opts.traceID != nil {
+		if traceID := p.opts.traceID(ctx); traceID != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderTraceID), Value: []byte(traceID)})
+		}
+	}
+	for _, inject := range p.opts.headerInjectors {
+		headers = append(headers, inject(ctx)...)
+	}
+	return headers
+}
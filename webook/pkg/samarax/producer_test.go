@@ -0,0 +1,116 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type traceKey struct{}
+
+func TestProducer_Sync(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	var got *sarama.ProducerMessage
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		got = msg
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(errors.New("mock error"))
+
+	var succeeded, failed int
+	cfg := DefaultSenderConfig()
+	cfg.MinSamples = 2
+	cfg.OnSuccess = func(msg *sarama.ProducerMessage) { succeeded++ }
+	cfg.OnError = func(msg *sarama.ProducerMessage, err error) { failed++ }
+	p := NewProducer[userEvent](NewSyncSender(producer, cfg), "user_events",
+		WithKeyFunc(func(evt userEvent) []byte { return []byte(strconv.FormatInt(evt.Uid, 10)) }),
+		WithEventType("UserRegistered"),
+		WithTraceID(func(ctx context.Context) string {
+			traceID, _ := ctx.Value(traceKey{}).(string)
+			return traceID
+		}))
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-123")
+	require.NoError(t, p.Produce(ctx, userEvent{Uid: 123, Nickname: "花里"}))
+	assert.Equal(t, "user_events", got.Topic)
+	key, err := got.Key.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "123", string(key))
+	val, err := got.Value.Encode()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"uid":123,"nickname":"花里"}`, string(val))
+	headers := toConsumerHeaders(got.Headers)
+	eventType, _ := Header(headers, HeaderEventType)
+	assert.Equal(t, "UserRegistered", eventType)
+	traceID, _ := Header(headers, HeaderTraceID)
+	assert.Equal(t, "trace-123", traceID)
+	_, ok := Header(headers, HeaderProducedAt)
+	assert.True(t, ok)
+
+	assert.Error(t, p.Produce(ctx, userEvent{Uid: 124}))
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 1, failed)
+	// 成功率 50%，不健康
+	assert.False(t, p.Healthy())
+	require.NoError(t, producer.Close())
+}
+
+func TestProducer_Async(t *testing.T) {
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, saramaCfg)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(errors.New("mock error"))
+
+	results := make(chan error, 2)
+	cfg := DefaultSenderConfig()
+	cfg.OnSuccess = func(msg *sarama.ProducerMessage) { results <- nil }
+	cfg.OnError = func(msg *sarama.ProducerMessage, err error) { results <- err }
+	sender := NewAsyncSender(producer, cfg)
+	p := NewProducer[userEvent](sender, "user_events")
+
+	require.NoError(t, p.Produce(context.Background(), userEvent{Uid: 1}))
+	require.NoError(t, p.Produce(context.Background(), userEvent{Uid: 2}))
+	// 成功和失败在不同的 goroutine 里面回调，顺序不一定
+	var errs []error
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 1)
+	require.NoError(t, sender.Close())
+}
+
+func TestLazySyncSender(t *testing.T) {
+	var calls int
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	s := NewLazySyncSender(func() (sarama.SyncProducer, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("连不上 broker")
+		}
+		return producer, nil
+	}, DefaultSenderConfig())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	msg := &sarama.ProducerMessage{Topic: "t", Value: sarama.StringEncoder("v")}
+
+	assert.ErrorIs(t, s.Send(context.Background(), msg), ErrProducerUnavailable)
+	// 没到重试间隔不会再连
+	assert.ErrorIs(t, s.Send(context.Background(), msg), ErrProducerUnavailable)
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(s.retryInterval)
+	require.NoError(t, s.Send(context.Background(), msg))
+	assert.Equal(t, 2, calls)
+	require.NoError(t, s.Close())
+}
@@ -0,0 +1,89 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy 处理消息失败之后的重试策略，间隔按照指数增长，再加上随机抖动，
+// 避免大家同时重试
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试几次，包括第一次。小于等于 1 就是不重试
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter 抖动的比例，0.2 代表在 [0.8, 1.2] 倍之间随机
+	Jitter float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+}
+
+// Backoff 第 attempt 次失败之后要等多久，attempt 从 1 开始
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	interval := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && interval > float64(p.MaxInterval) {
+		interval = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		interval *= 1 - p.Jitter + rand.Float64()*2*p.Jitter
+	}
+	return time.Duration(interval)
+}
+
+// Do 按照策略执行 fn，返回一共尝试了几次和最后一次的错误。
+// 不可重试的错误直接返回；ctx 结束了也直接返回 ctx 的错误
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) (int, error) {
+	attempt := 0
+	for {
+		attempt++
+		err := fn()
+		if err == nil || !IsRetryable(err) || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type nonRetryableError struct {
+	err error
+}
+
+func (e nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+// NonRetryable 标记这个错误重试也没用，比如参数不对，直接进死信队列
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return nonRetryableError{err: err}
+}
+
+// IsRetryable 默认所有错误都可以重试
+func IsRetryable(err error) bool {
+	var nre nonRetryableError
+	return !errors.As(err, &nre)
+}
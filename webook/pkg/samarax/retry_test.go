@@ -0,0 +1,166 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(3)
+		assert.GreaterOrEqual(t, d, 320*time.Millisecond)
+		assert.LessOrEqual(t, d, 480*time.Millisecond)
+	}
+	// 超过上限之后就不再增长
+	p.Jitter = 0
+	assert.Equal(t, time.Second, p.Backoff(10))
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 1}
+	mockErr := errors.New("mock error")
+
+	testCases := []struct {
+		name         string
+		fn           func(attempt int) error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "第二次成功",
+			fn:           func(attempt int) error { return map[int]error{1: mockErr}[attempt] },
+			wantAttempts: 2,
+		},
+		{
+			name:         "一直失败",
+			fn:           func(attempt int) error { return mockErr },
+			wantAttempts: 3,
+			wantErr:      mockErr,
+		},
+		{
+			name:         "不可重试的错误",
+			fn:           func(attempt int) error { return NonRetryable(mockErr) },
+			wantAttempts: 1,
+			wantErr:      mockErr,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			attempts, err := p.Do(context.Background(), func() error {
+				calls++
+				return tc.fn(calls)
+			})
+			assert.Equal(t, tc.wantAttempts, attempts)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+
+	// 等待重试的时候 ctx 结束了
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.InitialInterval = time.Hour
+	attempts, err := p.Do(ctx, func() error { return mockErr })
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDeadLetter_Send(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	var got *sarama.ProducerMessage
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		got = msg
+		return nil
+	})
+	dlq := NewDeadLetter(producer)
+	err := dlq.Send(&sarama.ConsumerMessage{
+		Topic:     "user_events",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("123"),
+		Value:     []byte(`{"uid":123}`),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("abc")}},
+	}, errors.New("mock error"), 3)
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+
+	assert.Equal(t, "user_events.dlq", got.Topic)
+	headers := make(map[string]string, len(got.Headers))
+	for _, h := range got.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, map[string]string{
+		"trace-id":                 "abc",
+		HeaderDLQError:             "mock error",
+		HeaderDLQAttempts:          "3",
+		HeaderDLQOriginalTopic:     "user_events",
+		HeaderDLQOriginalPartition: "2",
+		HeaderDLQOriginalOffset:    "42",
+	}, headers)
+}
+
+func TestHandler_DeadLetter(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts func(cluster *kafkatest.Cluster) []Option
+		// 处理失败之后提交到哪里
+		wantCommitted int64
+		wantErr       error
+		wantDLQ       int
+	}{
+		{
+			name: "死信队列",
+			opts: func(cluster *kafkatest.Cluster) []Option {
+				return []Option{WithDeadLetter(NewDeadLetter(cluster.SyncProducer()))}
+			},
+			wantCommitted: 2,
+			wantDLQ:       1,
+		},
+		{
+			// 默认不能悄悄丢掉，不提交，等着重新投递
+			name:          "没有死信队列",
+			opts:          func(cluster *kafkatest.Cluster) []Option { return nil },
+			wantCommitted: 0,
+			wantErr:       ErrNoDeadLetter,
+		},
+		{
+			name:          "明确要求丢掉",
+			opts:          func(cluster *kafkatest.Cluster) []Option { return []Option{WithDropFailed()} },
+			wantCommitted: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := kafkatest.NewCluster()
+			cluster.Publish("t", 0, nil, []byte(`"bad"`))
+			cluster.Publish("t", 0, nil, []byte(`"ok"`))
+			h := NewHandler[string](logger.NewNopLogger(),
+				func(msg *sarama.ConsumerMessage, event string) error {
+					if event == "bad" {
+						return NonRetryable(errors.New("mock error"))
+					}
+					return nil
+				}, tc.opts(cluster)...)
+			_, err := cluster.Consume("g", "t", 0, h)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantCommitted, cluster.Committed("g", "t", 0))
+			assert.Len(t, cluster.Messages("t.dlq", 0), tc.wantDLQ)
+		})
+	}
+}
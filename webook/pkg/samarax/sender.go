@@ -0,0 +1,216 @@
+package samarax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"gochuji/webook/pkg/ringBuffer"
+)
+
+// Sender 发送已经编码好的消息，Producer[T] 和死信队列、outbox 都建立在它上面
+type Sender interface {
+	Send(ctx context.Context, msg *sarama.ProducerMessage) error
+}
+
+// SenderConfig 同步和异步发送共用的配置
+type SenderConfig struct {
+	// Health 记录每次发送是否成功，为 nil 就不记录
+	Health *ringBuffer.Bit1024HealthBuffer
+	// 判断健康的阈值，见 Bit1024HealthBuffer.IsHealthy
+	MinSuccessRate float64
+	MinSamples     int
+
+	// 投递结果的回调，异步模式下在后台的 goroutine 里面调用
+	OnSuccess func(msg *sarama.ProducerMessage)
+	OnError   func(msg *sarama.ProducerMessage, err error)
+}
+
+func DefaultSenderConfig() SenderConfig {
+	return SenderConfig{
+		Health:         ringBuffer.NewBit1024HealthBuffer(),
+		MinSuccessRate: 0.9,
+		MinSamples:     100,
+	}
+}
+
+func (c SenderConfig) report(msg *sarama.ProducerMessage, err error) {
+	if c.Health != nil {
+		c.Health.Push(err == nil)
+	}
+	if err == nil {
+		if c.OnSuccess != nil {
+			c.OnSuccess(msg)
+		}
+		return
+	}
+	if c.OnError != nil {
+		c.OnError(msg, err)
+	}
+}
+
+// Healthy 没有配置 Health 的时候总是健康的
+func (c SenderConfig) Healthy() bool {
+	if c.Health == nil {
+		return true
+	}
+	return c.Health.IsHealthy(c.MinSuccessRate, c.MinSamples)
+}
+
+// Batching 生产者攒批的配置，只对异步模式有意义
+type Batching struct {
+	// 攒够多少条就发送
+	Messages int
+	// 攒够多少字节就发送
+	Bytes int
+	// 最多等多久
+	Frequency time.Duration
+	// 一次请求最多多少条，0 代表不限制
+	MaxMessages int
+}
+
+// Apply 写到 sarama 的配置里面，要在创建 sarama.AsyncProducer 之前调用
+func (b Batching) Apply(cfg *sarama.Config) {
+	cfg.Producer.Flush.Messages = b.Messages
+	cfg.Producer.Flush.Bytes = b.Bytes
+	cfg.Producer.Flush.Frequency = b.Frequency
+	cfg.Producer.Flush.MaxMessages = b.MaxMessages
+}
+
+// SyncSender 同步发送，Send 返回的时候 broker 已经确认了
+type SyncSender struct {
+	producer sarama.SyncProducer
+	cfg      SenderConfig
+}
+
+func NewSyncSender(producer sarama.SyncProducer, cfg SenderConfig) *SyncSender {
+	return &SyncSender{producer: producer, cfg: cfg}
+}
+
+func (s *SyncSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	_, _, err := s.producer.SendMessage(msg)
+	s.cfg.report(msg, err)
+	return err
+}
+
+func (s *SyncSender) Healthy() bool {
+	return s.cfg.Healthy()
+}
+
+// ErrProducerUnavailable LazySyncSender 还没连上 Kafka
+var ErrProducerUnavailable = errors.New("samarax: Kafka 生产者还没有创建成功")
+
+// LazySyncSender 第一次发送的时候才创建 sarama.SyncProducer，失败了隔 retryInterval 再试。
+// 启动的时候 Kafka 挂了也不影响别的功能，比如 outbox 先把消息存着，等 Kafka 恢复了再发
+type LazySyncSender struct {
+	newProducer   func() (sarama.SyncProducer, error)
+	cfg           SenderConfig
+	retryInterval time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	sender  *SyncSender
+	lastTry time.Time
+	lastErr error
+}
+
+func NewLazySyncSender(newProducer func() (sarama.SyncProducer, error), cfg SenderConfig) *LazySyncSender {
+	return &LazySyncSender{
+		newProducer:   newProducer,
+		cfg:           cfg,
+		retryInterval: 5 * time.Second,
+		now:           time.Now,
+	}
+}
+
+func (s *LazySyncSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	sender, err := s.get()
+	if err != nil {
+		s.cfg.report(msg, err)
+		return err
+	}
+	return sender.Send(ctx, msg)
+}
+
+func (s *LazySyncSender) Healthy() bool {
+	return s.cfg.Healthy()
+}
+
+func (s *LazySyncSender) get() (*SyncSender, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sender != nil {
+		return s.sender, nil
+	}
+	now := s.now()
+	if !s.lastTry.IsZero() && now.Sub(s.lastTry) < s.retryInterval {
+		return nil, fmt.Errorf("%w: %w", ErrProducerUnavailable, s.lastErr)
+	}
+	s.lastTry = now
+	producer, err := s.newProducer()
+	if err != nil {
+		s.lastErr = err
+		return nil, fmt.Errorf("%w: %w", ErrProducerUnavailable, err)
+	}
+	s.sender = NewSyncSender(producer, s.cfg)
+	return s.sender, nil
+}
+
+// Close 已经创建了生产者的话关掉它
+func (s *LazySyncSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sender == nil {
+		return nil
+	}
+	return s.sender.producer.Close()
+}
+
+// AsyncSender 异步发送，Send 只是放进 sarama 的发送队列，结果通过回调通知。
+// 创建 sarama.AsyncProducer 的时候必须打开 Producer.Return.Successes 和 Producer.Return.Errors
+type AsyncSender struct {
+	producer sarama.AsyncProducer
+	cfg      SenderConfig
+	wg       sync.WaitGroup
+}
+
+func NewAsyncSender(producer sarama.AsyncProducer, cfg SenderConfig) *AsyncSender {
+	s := &AsyncSender{producer: producer, cfg: cfg}
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		for msg := range producer.Successes() {
+			s.cfg.report(msg, nil)
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		for pe := range producer.Errors() {
+			s.cfg.report(pe.Msg, pe.Err)
+		}
+	}()
+	return s
+}
+
+func (s *AsyncSender) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.producer.Input() <- msg:
+		return nil
+	}
+}
+
+func (s *AsyncSender) Healthy() bool {
+	return s.cfg.Healthy()
+}
+
+// Close 等发送队列里面的消息都有了结果再返回
+func (s *AsyncSender) Close() error {
+	err := s.producer.Close()
+	s.wg.Wait()
+	return err
+}
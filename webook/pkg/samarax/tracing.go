@@ -0,0 +1,180 @@
+package samarax
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"gochuji/webook/pkg/logger"
+)
+
+const tracerName = "gochuji/webook/pkg/samarax"
+
+// WithTracerProvider 默认用 otel 全局的 TracerProvider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// tracer 每次都重新取，main 里面可能是在创建 Handler 之后才设置全局的 TracerProvider
+func (o options) tracer() trace.Tracer {
+	tp := o.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// producerCarrier 把 W3C trace context 写到生产者消息的头部
+type producerCarrier struct {
+	headers *[]sarama.RecordHeader
+}
+
+func (c producerCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c producerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if string(h.Key) == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c producerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+// consumerCarrier 从消费者消息的头部读出 trace context
+type consumerCarrier []*sarama.RecordHeader
+
+func (c consumerCarrier) Get(key string) string {
+	v, _ := Header(c, key)
+	return v
+}
+
+func (c consumerCarrier) Set(key, value string) {}
+
+func (c consumerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, h := range c {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
+
+// Inject 把 ctx 里面的 trace context 写到头部，用的是 otel 全局的 propagator
+func Inject(ctx context.Context, headers *[]sarama.RecordHeader) {
+	otel.GetTextMapPropagator().Inject(ctx, producerCarrier{headers: headers})
+}
+
+// Extract 从消息的头部恢复生产者那边的 trace context
+func Extract(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, consumerCarrier(msg.Headers))
+}
+
+var _ propagation.TextMapCarrier = producerCarrier{}
+var _ propagation.TextMapCarrier = consumerCarrier{}
+
+// msgCtxs 正在处理的消息 -> 处理它的 span 所在的 context
+var msgCtxs sync.Map
+
+// MessageContext 在 Handler、BatchHandler 的 fn 里面调用，拿到处理这条消息的 span 所在的 context，
+// 业务代码用它创建的 span 会挂在消费的 span 下面，logger.FromContext 拿到的 logger 带上了消息的位置。
+// 不在处理中的消息就直接从头部恢复
+func MessageContext(msg *sarama.ConsumerMessage) context.Context {
+	if ctx, ok := msgCtxs.Load(msg); ok {
+		return ctx.(context.Context)
+	}
+	return Extract(context.Background(), msg)
+}
+
+// messageContext 给 ctx 带上这条消息专属的 logger，并且登记下来给 MessageContext 用
+func messageContext(ctx context.Context, l logger.LoggerV1, msg *sarama.ConsumerMessage) (context.Context, logger.LoggerV1) {
+	fields := []logger.Field{
+		logger.String("topic", msg.Topic),
+		logger.Int32("partition", msg.Partition),
+		logger.Int64("offset", msg.Offset),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, logger.String("trace_id", sc.TraceID().String()))
+	}
+	l = l.With(fields...)
+	ctx = logger.WithContext(ctx, l)
+	msgCtxs.Store(msg, ctx)
+	return ctx, l
+}
+
+func messagingAttrs(topic string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "kafka"),
+		attribute.String("messaging.destination.name", topic),
+	}
+}
+
+func startProducerSpan(ctx context.Context, o options, topic string) (context.Context, trace.Span) {
+	return o.tracer().Start(ctx, topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttrs(topic)...))
+}
+
+// startConsumerSpan 消费的 span 是生产者 span 的子 span
+func startConsumerSpan(ctx context.Context, o options, msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	ctx = Extract(ctx, msg)
+	attrs := append(messagingAttrs(msg.Topic),
+		attribute.String("messaging.destination.partition.id", strconv.Itoa(int(msg.Partition))),
+		attribute.Int64("messaging.kafka.offset", msg.Offset))
+	return o.tracer().Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+}
+
+// startBatchSpan 一批消息可能来自不同的 trace，所以不挂在任何一个下面，而是链接到每一条
+func startBatchSpan(ctx context.Context, o options, batch []*sarama.ConsumerMessage) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, msg := range batch {
+		sc := trace.SpanContextFromContext(Extract(context.Background(), msg))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	attrs := append(messagingAttrs(batch[0].Topic),
+		attribute.Int("messaging.batch.message_count", len(batch)))
+	return o.tracer().Start(ctx, batch[0].Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attrs...))
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func forgetMessages(msgs ...*sarama.ConsumerMessage) {
+	for _, msg := range msgs {
+		msgCtxs.Delete(msg)
+	}
+}
@@ -0,0 +1,73 @@
+package samarax
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"gochuji/webook/pkg/logger"
+	"gochuji/webook/pkg/otelx"
+	"gochuji/webook/pkg/samarax/kafkatest"
+)
+
+func TestTracePropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tp, exporter := otelx.NewInMemory()
+	cluster := kafkatest.NewCluster()
+
+	p := NewProducer[string](cluster, "t", WithTracerProvider(tp))
+	require.NoError(t, p.Produce(context.Background(), "hello"))
+
+	var fnSpan trace.SpanContext
+	h := NewHandler[string](logger.NewZapLogger(zap.NewNop()),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			fnSpan = trace.SpanContextFromContext(MessageContext(msg))
+			return nil
+		}, WithTracerProvider(tp))
+	_, err := cluster.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	producer, consumer := spans[0], spans[1]
+	assert.Equal(t, trace.SpanKindProducer, producer.SpanKind)
+	assert.Equal(t, trace.SpanKindConsumer, consumer.SpanKind)
+	// 消费的 span 挂在生产的 span 下面，fn 里面拿到的是消费的 span
+	assert.Equal(t, producer.SpanContext.TraceID(), consumer.SpanContext.TraceID())
+	assert.Equal(t, producer.SpanContext.SpanID(), consumer.Parent.SpanID())
+	assert.Equal(t, consumer.SpanContext.SpanID(), fnSpan.SpanID())
+}
+
+func TestMessageContextLogger(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tp, _ := otelx.NewInMemory()
+	cluster := kafkatest.NewCluster()
+	p := NewProducer[string](cluster, "t", WithTracerProvider(tp))
+	require.NoError(t, p.Produce(context.Background(), "hello"))
+
+	core, logs := observer.New(zap.DebugLevel)
+	h := NewHandler[string](logger.NewZapLogger(zap.New(core)),
+		func(msg *sarama.ConsumerMessage, event string) error {
+			logger.FromContext(MessageContext(msg)).Info("业务日志")
+			return nil
+		}, WithTracerProvider(tp))
+	_, err := cluster.Consume("g", "t", 0, h)
+	require.NoError(t, err)
+
+	// 业务代码打的日志带上了消息的位置和 trace id
+	entries := logs.FilterMessage("业务日志").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "t", fields["topic"])
+	assert.Equal(t, int32(0), fields["partition"])
+	assert.Equal(t, int64(0), fields["offset"])
+	assert.NotEmpty(t, fields["trace_id"])
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage 存在本地磁盘上，开发环境用。
+// 需要自己把 root 目录挂到 baseURL 上，比如 gin 的 server.Static
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStorage(root string, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// 先写临时文件再 rename，避免别人读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// path 防止 key 里面带 .. 跑到 root 外面去
+func (s *LocalStorage) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("非法的 key: %q", key)
+	}
+	return filepath.Join(s.root, cleaned), nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	s := NewLocalStorage(root, "http://localhost:8080/static/")
+
+	err := s.Put(context.Background(), "avatars/1/a.jpg", strings.NewReader("hello"), 5, "image/jpeg")
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(root, "avatars", "1", "a.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "http://localhost:8080/static/avatars/1/a.jpg", s.URL("avatars/1/a.jpg"))
+
+	// .. 不能跑到 root 外面去
+	err = s.Put(context.Background(), "../../etc/a.jpg", strings.NewReader("hello"), 5, "image/jpeg")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(root, "etc", "a.jpg"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Delete(context.Background(), "avatars/1/a.jpg"))
+	assert.Equal(t, ErrObjectNotFound, s.Delete(context.Background(), "avatars/1/a.jpg"))
+}
+
+func TestS3Storage(t *testing.T) {
+	var gotMethod, gotPath, gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotAuth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	s := NewS3Storage(S3Config{
+		Endpoint:  server.URL,
+		Region:    "ap-guangzhou",
+		Bucket:    "webook",
+		AccessKey: "AK",
+		SecretKey: "SK",
+	}, server.Client())
+	s.now = func() time.Time { return time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC) }
+
+	err := s.Put(context.Background(), "avatars/1/a.jpg", strings.NewReader("hello"), 5, "image/jpeg")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, gotMethod)
+	assert.Equal(t, "/webook/avatars/1/a.jpg", gotPath)
+	assert.Equal(t, "hello", gotBody)
+	assert.True(t, strings.HasPrefix(gotAuth,
+		"AWS4-HMAC-SHA256 Credential=AK/20250801/ap-guangzhou/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature="))
+
+	assert.Equal(t, ErrObjectNotFound, s.Delete(context.Background(), "avatars/1/a.jpg"))
+	assert.Equal(t, server.URL+"/webook/avatars/1/a.jpg", s.URL("avatars/1/a.jpg"))
+}
+
+// TestSigningKey 用的是 AWS 文档里面的例子
+func TestSigningKey(t *testing.T) {
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
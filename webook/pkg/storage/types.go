@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrObjectNotFound = errors.New("对象不存在")
+
+// ObjectStorage 对象存储的抽象，key 用 / 分隔，比如 avatars/123/xxx.jpg
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL 返回可以直接给前端访问的地址
+	URL(key string) string
+}